@@ -0,0 +1,256 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// envPrefix is the prefix of environment variables bound to flags.
+	envPrefix = "PROMMUX_"
+	// configFlagName is the name of the flag to specify the config file.
+	configFlagName = "config"
+	// helpFlagName is the name of the flag cobra adds to show the help.
+	helpFlagName = "help"
+)
+
+const (
+	// flagSourceFlag indicates the value was given on the command line.
+	flagSourceFlag = "flag"
+	// flagSourceEnv indicates the value was read from an environment variable.
+	flagSourceEnv = "env"
+	// flagSourceConfig indicates the value was read from the config file.
+	flagSourceConfig = "config"
+	// flagSourceDefault indicates the default value of the flag is used.
+	flagSourceDefault = "default"
+)
+
+var (
+	configFile string
+	// flagSources holds the source of the value for each flag of the running command.
+	flagSources map[string]string
+	// boundCommands holds the commands bound to the config file with their sections,
+	// so that every command accepts the config file shared with the others.
+	boundCommands = make(map[*cobra.Command][]configSection)
+)
+
+// configSection is a structured section of the config file.
+// It is decoded into target instead of being bound to a flag, since flags cannot express it.
+type configSection struct {
+	key    string
+	target any
+}
+
+// envName returns the name of environment variable bound to the flag.
+// e.g. `docker-address` is bound to `PROMMUX_DOCKER_ADDRESS`.
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// readConfigFile reads the YAML config file and returns its values keyed by flag name.
+// The structured sections are decoded into their targets, rejecting unknown fields.
+// The sections of the other commands are skipped.
+func readConfigFile(path string, sections []configSection) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config file: %w", err)
+	}
+
+	raw := make(map[string]yaml.Node)
+	err = yaml.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+	for _, s := range sections {
+		node, ok := raw[s.key]
+		if !ok {
+			continue
+		}
+		delete(raw, s.key)
+		err = decodeConfigSection(&node, s.target)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse `%s` in config file: %w", s.key, err)
+		}
+	}
+	for k := range raw {
+		if isConfigSection(k) {
+			delete(raw, k)
+		}
+	}
+
+	ret := make(map[string]string, len(raw))
+	for k, node := range raw {
+		var v any
+		err = node.Decode(&v)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse `%s` in config file: %w", k, err)
+		}
+		switch val := v.(type) {
+		case []any:
+			values := make([]string, 0, len(val))
+			for _, e := range val {
+				values = append(values, fmt.Sprint(e))
+			}
+			ret[k] = strings.Join(values, ",")
+		case map[string]any:
+			return nil, fmt.Errorf("the value of `%s` in config file must not be a mapping", k)
+		default:
+			ret[k] = fmt.Sprint(val)
+		}
+	}
+	return ret, nil
+}
+
+// decodeConfigSection decodes node into target strictly.
+// yaml.Node.Decode does not reject unknown fields, so node is encoded again to be decoded by Decoder.
+func decodeConfigSection(node *yaml.Node, target any) error {
+	data, err := yaml.Marshal(node)
+	if err != nil {
+		return err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	return dec.Decode(target)
+}
+
+// resolveFlags fills the flags which are not given on the command line
+// with environment variables and the config file, in that order of precedence.
+// It returns the source of the effective value for each flag.
+func resolveFlags(fs *pflag.FlagSet, sections []configSection) (map[string]string, error) {
+	sources := make(map[string]string)
+	fs.VisitAll(func(f *pflag.Flag) {
+		if f.Changed {
+			sources[f.Name] = flagSourceFlag
+		}
+	})
+
+	// the path to the config file itself can be given by env
+	if f := fs.Lookup(configFlagName); f != nil && !f.Changed {
+		if v, ok := os.LookupEnv(envName(configFlagName)); ok {
+			err := fs.Set(configFlagName, v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid value for `%s` from environment variable `%s`: %w", configFlagName, envName(configFlagName), err)
+			}
+			sources[configFlagName] = flagSourceEnv
+		}
+	}
+
+	var configValues map[string]string
+	if configFile != "" {
+		var err error
+		configValues, err = readConfigFile(configFile, sections)
+		if err != nil {
+			return nil, err
+		}
+		unknownKeys := make([]string, 0)
+		for k := range configValues {
+			if !isBoundFlag(k) || (fs.Lookup(k) == nil && !isKnownFlag(k)) {
+				unknownKeys = append(unknownKeys, k)
+			}
+		}
+		if len(unknownKeys) > 0 {
+			sort.Strings(unknownKeys)
+			return nil, fmt.Errorf("unknown keys in config file `%s`: %s", configFile, strings.Join(unknownKeys, ", "))
+		}
+	}
+
+	var err error
+	fs.VisitAll(func(f *pflag.Flag) {
+		if err != nil {
+			return
+		}
+		if _, ok := sources[f.Name]; ok {
+			return
+		}
+		if !isBoundFlag(f.Name) {
+			sources[f.Name] = flagSourceDefault
+			return
+		}
+		if v, ok := os.LookupEnv(envName(f.Name)); ok {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value for `%s` from environment variable `%s`: %w", f.Name, envName(f.Name), setErr)
+				return
+			}
+			sources[f.Name] = flagSourceEnv
+			return
+		}
+		if v, ok := configValues[f.Name]; ok {
+			if setErr := fs.Set(f.Name, v); setErr != nil {
+				err = fmt.Errorf("invalid value for `%s` in config file: %w", f.Name, setErr)
+				return
+			}
+			sources[f.Name] = flagSourceConfig
+			return
+		}
+		sources[f.Name] = flagSourceDefault
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sources, nil
+}
+
+// isBoundFlag reports whether the flag of name can be read from environment variables and the config file.
+// The config file itself can be given only by the flag and env, and the help only by the flag.
+func isBoundFlag(name string) bool {
+	return name != configFlagName && name != helpFlagName
+}
+
+// isKnownFlag reports whether any command bound to the config file has the flag of name.
+// The config file can be shared among the commands, so that the flags of the others are ignored instead of being rejected.
+func isKnownFlag(name string) bool {
+	for cmd := range boundCommands {
+		if cmd.Flags().Lookup(name) != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// isConfigSection reports whether key is a section of any command bound to the config file.
+func isConfigSection(key string) bool {
+	for _, sections := range boundCommands {
+		for _, s := range sections {
+			if s.key == key {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// bindFlagSources lets the flags of cmd be read from environment variables and the config file.
+// The precedence is flag > env > config file > default.
+// sections are the structured sections which are read only from the config file.
+func bindFlagSources(cmd *cobra.Command, sections ...configSection) {
+	cmd.Flags().StringVarP(&configFile, configFlagName, "c", "", "the path to YAML config file. its keys are the names of flags. it can be shared among the commands.")
+	boundCommands[cmd] = sections
+
+	preRunE := cmd.PreRunE
+	cmd.PreRunE = func(cmd *cobra.Command, args []string) error {
+		sources, err := resolveFlags(cmd.Flags(), sections)
+		if err != nil {
+			return err
+		}
+		flagSources = sources
+		if preRunE != nil {
+			return preRunE(cmd, args)
+		}
+		return nil
+	}
+
+	usage := cmd.Long
+	if usage == "" {
+		usage = cmd.Short
+	}
+	cmd.Long = usage + "\n\nEvery flag can also be set by the environment variable named `" + envPrefix +
+		"<FLAG_NAME>` (e.g. `--log-level` by `" + envName("log-level") + "`) or by the config file.\n" +
+		"The precedence is flag > environment variable > config file > default."
+}
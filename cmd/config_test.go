@@ -0,0 +1,208 @@
+package cmd
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/spf13/pflag"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+func writeConfigFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "prommux.yml")
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestReadConfigFileSections(t *testing.T) {
+	var views []*handler.ViewParams
+	sections := []configSection{{key: "views", target: &views}}
+
+	values, err := readConfigFile(writeConfigFile(t, `
+log-level: debug
+filter: [a, b]
+views:
+  - name: apps
+    selector:
+      com.docker.compose.project: shop
+    relabel_configs:
+      - source_labels: [prommux_scrape_url]
+        target_label: url
+`), sections)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["log-level"] != "debug" || values["filter"] != "a,b" {
+		t.Errorf("unexpected values of flags: %v", values)
+	}
+	if _, ok := values["views"]; ok {
+		t.Error("the section must not be bound to a flag")
+	}
+	if len(views) != 1 || views[0].Name != "apps" || len(views[0].RelabelConfigs) != 1 {
+		t.Fatalf("unexpected views: %+v", views)
+	}
+	if got := views[0].RelabelConfigs[0].Action; got != "replace" {
+		t.Errorf("the default action of relabeling must be applied. got: %s", got)
+	}
+
+	for name, content := range map[string]string{
+		"unknown field":  "views:\n  - name: apps\n    selectors: {}\n",
+		"mapping":        "views:\n  name: apps\n",
+		"flag mapping":   "log-level:\n  name: debug\n",
+		"invalid action": "views:\n  - name: apps\n    relabel_configs:\n      - action: rename\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := readConfigFile(writeConfigFile(t, content), sections)
+			if err == nil {
+				t.Error("error must be returned")
+			}
+		})
+	}
+}
+
+// newTestFlagSet returns the flags for the tests of resolveFlags, in the same shape as the ones of the commands.
+func newTestFlagSet(t *testing.T) *pflag.FlagSet {
+	t.Helper()
+	fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+	fs.String("from-flag", "default", "")
+	fs.String("from-env", "default", "")
+	fs.String("from-config", "default", "")
+	fs.String("from-default", "default", "")
+	fs.StringSlice("list", nil, "")
+	fs.Bool(helpFlagName, false, "")
+	fs.StringVarP(&configFile, configFlagName, "c", "", "")
+	t.Cleanup(func() { configFile = "" })
+	return fs
+}
+
+func TestResolveFlagsPrecedence(t *testing.T) {
+	fs := newTestFlagSet(t)
+	path := writeConfigFile(t, `
+from-flag: config
+from-env: config
+from-config: config
+list: [a, b]
+`)
+	t.Setenv(envName(configFlagName), path)
+	t.Setenv(envName("from-flag"), "env")
+	t.Setenv(envName("from-env"), "env")
+	t.Setenv(envName(helpFlagName), "true")
+
+	err := fs.Parse([]string{"--from-flag=flag"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sources, err := resolveFlags(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	wantValues := map[string]string{
+		"from-flag":    "flag",
+		"from-env":     "env",
+		"from-config":  "config",
+		"from-default": "default",
+		"list":         "[a,b]",
+		helpFlagName:   "false",
+		configFlagName: path,
+	}
+	gotValues := make(map[string]string)
+	fs.VisitAll(func(f *pflag.Flag) {
+		gotValues[f.Name] = f.Value.String()
+	})
+	if diff := cmp.Diff(wantValues, gotValues); diff != "" {
+		t.Errorf("unexpected values of flags (-want +got):\n%s", diff)
+	}
+
+	wantSources := map[string]string{
+		"from-flag":    flagSourceFlag,
+		"from-env":     flagSourceEnv,
+		"from-config":  flagSourceConfig,
+		"from-default": flagSourceDefault,
+		"list":         flagSourceConfig,
+		helpFlagName:   flagSourceDefault,
+		configFlagName: flagSourceEnv,
+	}
+	if diff := cmp.Diff(wantSources, sources); diff != "" {
+		t.Errorf("unexpected sources of flags (-want +got):\n%s", diff)
+	}
+}
+
+func TestResolveFlagsListFromEnv(t *testing.T) {
+	fs := newTestFlagSet(t)
+	t.Setenv(envName("list"), "a,b,c")
+
+	sources, err := resolveFlags(fs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := fs.GetStringSlice("list")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a", "b", "c"}, got); diff != "" {
+		t.Errorf("unexpected list (-want +got):\n%s", diff)
+	}
+	if sources["list"] != flagSourceEnv {
+		t.Errorf("unexpected source of list: %s", sources["list"])
+	}
+}
+
+func TestResolveFlagsUnboundKeys(t *testing.T) {
+	for _, key := range []string{helpFlagName, configFlagName, "unknown"} {
+		t.Run(key, func(t *testing.T) {
+			fs := newTestFlagSet(t)
+			configFile = writeConfigFile(t, key+": true\n")
+			_, err := resolveFlags(fs, nil)
+			if err == nil {
+				t.Errorf("key `%s` in config file must be rejected", key)
+			}
+		})
+	}
+}
+
+// TestSharedConfigFile checks that the commands accept the config file shared with the others,
+// e.g. healthcheck in the container of the server.
+func TestSharedConfigFile(t *testing.T) {
+	healthy := newHealthServer(t, http.StatusOK, &handler.ResponseStatus{})
+	shared := writeConfigFile(t, `
+docker-address: unix:///var/run/docker.sock
+port: 11298
+log-level: error
+views:
+  - name: apps
+    selector:
+      com.docker.compose.project: shop
+tenants:
+  - name: shop
+    bearer_token: secret
+`)
+	unknown := writeConfigFile(t, "log-level: error\nunknown-key: true\n")
+
+	for name, tc := range map[string]struct {
+		args []string
+		want int
+	}{
+		"healthcheck with server config": {args: []string{"healthcheck", "--url", healthy + "/-/ready", "--config", shared}, want: exitCodeHealthy},
+		"config from env":                {args: []string{"healthcheck", "--url", healthy + "/-/ready"}, want: exitCodeHealthy},
+		"unknown key":                    {args: []string{"healthcheck", "--url", healthy + "/-/ready", "--config", unknown}, want: exitCodeError},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(func() { resetFlags(t, healthCheckCmd) })
+			t.Setenv(envName(configFlagName), shared)
+			rootCmd.SetArgs(tc.args)
+
+			err := rootCmd.Execute()
+			if got := exitCode(err); got != tc.want {
+				t.Errorf("unexpected exit code. want: %d, got: %d, err: %v", tc.want, got, err)
+			}
+		})
+	}
+}
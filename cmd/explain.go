@@ -0,0 +1,139 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+const outputFormatText = "text"
+
+// explainCmd explains how the labels of a container become the URL to scrape.
+var explainCmd = &cobra.Command{
+	Use:   "explain <container-id|name>",
+	Short: "Explain how the labels of a container become the URL to scrape",
+	Long: "Connect to Docker API once and trace how the labels of the container become the URL to scrape and the entry of discover API. " +
+		"The same information is served by `/debug/explain/{id}` endpoint of the server.",
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level, err := setLogLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("failed to set log level: %w", err)
+		}
+
+		// stdout is for the result
+		logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+		explanations, err := runDiscoveryOnce(cmd.Context(), logger, func(h *handler.Handler) ([]*handler.Explanation, error) {
+			return h.Explain(args[0], renderScheme, renderTargetAddress), nil
+		})
+		if err != nil {
+			return err
+		}
+		if len(explanations) == 0 {
+			return fmt.Errorf("no target found for the container `%s`", args[0])
+		}
+
+		return writeExplanations(cmd.OutOrStdout(), explanations, explainOutput)
+	},
+}
+
+// writeExplanations writes explanations to w in the given format.
+func writeExplanations(w io.Writer, explanations []*handler.Explanation, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(explanations)
+	case outputFormatText:
+		for i, e := range explanations {
+			if i > 0 {
+				fmt.Fprintln(w)
+			}
+			writeExplanationText(w, e)
+		}
+		return nil
+	default:
+		return fmt.Errorf("unknown output format `%s`. (candidates: text, json)", format)
+	}
+}
+
+func writeExplanationText(w io.Writer, e *handler.Explanation) {
+	fmt.Fprintf(w, "container: %s (%s)\n", e.ContainerName, e.ContainerID)
+
+	fmt.Fprintln(w, "meta labels:")
+	names := make([]string, 0, len(e.MetaLabels))
+	for k := range e.MetaLabels {
+		names = append(names, string(k))
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(w, "  %s=%q\n", name, e.MetaLabels[model.LabelName(name)])
+	}
+
+	if p := e.TemplateParams; p != nil {
+		fmt.Fprintln(w, "template params:")
+		fmt.Fprintf(w, "  .OriginalHost=%q\n", p.OriginalHost)
+		fmt.Fprintf(w, "  .OriginalPort=%q\n", p.OriginalPort)
+		fmt.Fprintf(w, "  .OriginalMetricsPath=%q\n", p.OriginalMetricsPath)
+	}
+
+	fmt.Fprintln(w, "overrides:")
+	if len(e.Overrides) == 0 {
+		fmt.Fprintln(w, "  (none)")
+	}
+	for _, o := range e.Overrides {
+		if o.Error != "" {
+			fmt.Fprintf(w, "  %s: %q -> error: %s\n", o.Label, o.Template, o.Error)
+			continue
+		}
+		fmt.Fprintf(w, "  %s: %q: %q -> %q\n", o.Label, o.Template, o.Before, o.After)
+	}
+
+	if e.Error != "" {
+		fmt.Fprintf(w, "error: %s\n", e.Error)
+		return
+	}
+	fmt.Fprintf(w, "url: %s\n", e.URL)
+	fmt.Fprintf(w, "hash: %s\n", e.Hash)
+
+	if e.Dedup != nil {
+		if e.Dedup.Kept {
+			fmt.Fprintln(w, "dedup: kept (the first target for the URL)")
+		} else {
+			fmt.Fprintf(w, "dedup: dropped (duplicate of the target of %s)\n", e.Dedup.DuplicateOf)
+		}
+	}
+
+	if f := e.Filtering; f != nil {
+		fmt.Fprintf(w, "label filtering: include_docker_labels=%t regexp=%q\n", f.IncludeDockerLabels, f.Regexp)
+		fmt.Fprintf(w, "  kept: %s\n", strings.Join(f.Kept, ", "))
+		fmt.Fprintf(w, "  dropped: %s\n", strings.Join(f.Dropped, ", "))
+	}
+
+	if e.StaticConfig != nil {
+		data, err := json.Marshal(e.StaticConfig)
+		if err == nil {
+			fmt.Fprintf(w, "static config: %s\n", data)
+		}
+	}
+}
+
+var explainOutput string
+
+func init() {
+	addDiscovererFlags(explainCmd)
+	explainCmd.Flags().StringVar(&explainOutput, "output", outputFormatText, "the output format (text, json)")
+	explainCmd.Flags().StringVar(&renderScheme, "scheme", "http", "the scheme to reach prommux, written in each target")
+	explainCmd.Flags().StringVar(&renderTargetAddress, "target-address", "localhost:11298", "the address to reach prommux, written in each target")
+	bindFlagSources(explainCmd)
+	rootCmd.AddCommand(explainCmd)
+}
@@ -0,0 +1,106 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+func TestWriteExplanations(t *testing.T) {
+	explanations := []*handler.Explanation{
+		{
+			ContainerID:   "0123",
+			ContainerName: "/shop-web-1",
+			MetaLabels: model.LabelSet{
+				"__address__": "172.18.0.2:8080",
+				"__meta_docker_container_label_prommux_port": "9100",
+			},
+			TemplateParams: &handler.OverrideLabelsTemplateParams{
+				OriginalHost:        "172.18.0.2",
+				OriginalPort:        "8080",
+				OriginalMetricsPath: "/metrics",
+			},
+			Overrides: []*handler.ExplainOverride{
+				{Label: "prommux.port", Template: "9100", Before: "172.18.0.2:8080", After: "172.18.0.2:9100"},
+			},
+			URL:       "http://172.18.0.2:9100/metrics",
+			Hash:      "abc",
+			Dedup:     &handler.ExplainDedup{Kept: true},
+			Filtering: &handler.ExplainFiltering{Kept: []string{"job"}, Dropped: []string{"env", "team"}},
+			StaticConfig: &handler.StaticConfig{
+				Targets: []string{"prommux:11298"},
+				Labels:  model.LabelSet{"job": "node"},
+			},
+		},
+		{
+			ContainerID:   "0123",
+			ContainerName: "/shop-web-1",
+			Overrides: []*handler.ExplainOverride{
+				{Label: "prommux.path", Template: "{{ .Unknown }}", Error: "unknown field"},
+			},
+			Error: "failed to apply override labels",
+		},
+	}
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeExplanations(&buf, explanations, outputFormatText)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := `container: /shop-web-1 (0123)
+meta labels:
+  __address__="172.18.0.2:8080"
+  __meta_docker_container_label_prommux_port="9100"
+template params:
+  .OriginalHost="172.18.0.2"
+  .OriginalPort="8080"
+  .OriginalMetricsPath="/metrics"
+overrides:
+  prommux.port: "9100": "172.18.0.2:8080" -> "172.18.0.2:9100"
+url: http://172.18.0.2:9100/metrics
+hash: abc
+dedup: kept (the first target for the URL)
+label filtering: include_docker_labels=false regexp=""
+  kept: job
+  dropped: env, team
+static config: {"targets":["prommux:11298"],"labels":{"job":"node"}}
+
+container: /shop-web-1 (0123)
+meta labels:
+overrides:
+  prommux.path: "{{ .Unknown }}" -> error: unknown field
+error: failed to apply override labels
+`
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeExplanations(&buf, explanations, outputFormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*handler.Explanation
+		err = json.Unmarshal(buf.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("output is not JSON: %s", err)
+		}
+		if diff := cmp.Diff(explanations, got); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		err := writeExplanations(&bytes.Buffer{}, explanations, outputFormatTable)
+		if err == nil {
+			t.Error("error must be returned for unsupported format")
+		}
+	})
+}
@@ -0,0 +1,317 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/client"
+	"github.com/xruins/prommux/pkg/handler"
+	"gopkg.in/yaml.v3"
+)
+
+// the formats of genconfig command.
+const (
+	genconfigFormatPrometheus = "prometheus"
+	genconfigFormatOperator   = "operator"
+)
+
+// the meta labels of Docker SD promoted by the generated config.
+const (
+	metaLabelContainerName   = "__meta_docker_container_name"
+	metaLabelContainerLabels = "__meta_docker_container_label_"
+)
+
+// relabelRule is a rule of relabeling in the generated config.
+type relabelRule struct {
+	sourceLabels []string
+	regex        string
+	targetLabel  string
+	replacement  string
+	action       string
+}
+
+// genconfigParams is the parameters to generate the scrape config.
+type genconfigParams struct {
+	jobName         string
+	url             string
+	refreshInterval time.Duration
+	includeLabels   bool
+	// regexpLabels is the regexp to filter Docker labels on prommux. Every label is included if nil.
+	regexpLabels  *regexp.Regexp
+	dropScrapeURL bool
+	// bearerTokenFile is the file of the bearer token of a tenant on Prometheus, used by prometheus format.
+	bearerTokenFile string
+	// bearerTokenSecret is the Secret of the bearer token of a tenant in the form of `<name>:<key>`, used by operator format.
+	bearerTokenSecret string
+}
+
+// labelIncluded reports whether the Docker label is included in the response of discover endpoint.
+func (p *genconfigParams) labelIncluded(name string) bool {
+	return p.includeLabels && (p.regexpLabels == nil || p.regexpLabels.MatchString(name))
+}
+
+// relabelRules returns the rules to relabel the targets discovered from prommux.
+func (p *genconfigParams) relabelRules() []*relabelRule {
+	rules := []*relabelRule{
+		// every target has the address of prommux, so the instance is taken from the URL of the exporter
+		{
+			sourceLabels: []string{handler.LabelScrapeURL},
+			regex:        `[a-z]+://([^/]+).*`,
+			targetLabel:  model.InstanceLabel,
+			replacement:  "$1",
+			action:       "replace",
+		},
+	}
+	if p.labelIncluded(metaLabelContainerName) {
+		rules = append(rules, &relabelRule{
+			sourceLabels: []string{metaLabelContainerName},
+			regex:        "/?(.+)",
+			targetLabel:  "container",
+			replacement:  "$1",
+			action:       "replace",
+		})
+	}
+	if p.includeLabels {
+		rules = append(rules, &relabelRule{
+			regex:       metaLabelContainerLabels + "(.+)",
+			replacement: "$1",
+			action:      "labelmap",
+		})
+	}
+	if p.dropScrapeURL {
+		rules = append(rules, &relabelRule{
+			regex:  handler.LabelScrapeURL,
+			action: "labeldrop",
+		})
+	}
+	return rules
+}
+
+// the config of Prometheus.
+type promScrapeConfig struct {
+	JobName        string              `yaml:"job_name"`
+	Authorization  *promAuthorization  `yaml:"authorization,omitempty"`
+	HTTPSDConfigs  []*promHTTPSDConfig `yaml:"http_sd_configs"`
+	RelabelConfigs []*promRelabel      `yaml:"relabel_configs,omitempty"`
+}
+
+type promHTTPSDConfig struct {
+	URL             string             `yaml:"url"`
+	RefreshInterval string             `yaml:"refresh_interval,omitempty"`
+	Authorization   *promAuthorization `yaml:"authorization,omitempty"`
+}
+
+type promAuthorization struct {
+	Type            string `yaml:"type"`
+	CredentialsFile string `yaml:"credentials_file"`
+}
+
+type promRelabel struct {
+	SourceLabels []string `yaml:"source_labels,omitempty,flow"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"target_label,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action"`
+}
+
+// the manifest of ScrapeConfig of Prometheus Operator.
+type operatorScrapeConfig struct {
+	APIVersion string                   `yaml:"apiVersion"`
+	Kind       string                   `yaml:"kind"`
+	Metadata   operatorMetadata         `yaml:"metadata"`
+	Spec       operatorScrapeConfigSpec `yaml:"spec"`
+}
+
+type operatorMetadata struct {
+	Name      string `yaml:"name"`
+	Namespace string `yaml:"namespace,omitempty"`
+}
+
+type operatorScrapeConfigSpec struct {
+	JobName       string                  `yaml:"jobName"`
+	Authorization *operatorAuthorization  `yaml:"authorization,omitempty"`
+	HTTPSDConfigs []*operatorHTTPSDConfig `yaml:"httpSDConfigs"`
+	Relabelings   []*operatorRelabel      `yaml:"relabelings,omitempty"`
+}
+
+type operatorHTTPSDConfig struct {
+	URL             string                 `yaml:"url"`
+	RefreshInterval string                 `yaml:"refreshInterval,omitempty"`
+	Authorization   *operatorAuthorization `yaml:"authorization,omitempty"`
+}
+
+type operatorAuthorization struct {
+	Type        string                    `yaml:"type"`
+	Credentials operatorSecretKeySelector `yaml:"credentials"`
+}
+
+type operatorSecretKeySelector struct {
+	Name string `yaml:"name"`
+	Key  string `yaml:"key"`
+}
+
+type operatorRelabel struct {
+	SourceLabels []string `yaml:"sourceLabels,omitempty,flow"`
+	Regex        string   `yaml:"regex,omitempty"`
+	TargetLabel  string   `yaml:"targetLabel,omitempty"`
+	Replacement  string   `yaml:"replacement,omitempty"`
+	Action       string   `yaml:"action"`
+}
+
+// writeScrapeConfig writes the scrape config in the format to w.
+func writeScrapeConfig(w io.Writer, p *genconfigParams, format, namespace string) error {
+	discoverURL := strings.TrimSuffix(p.url, "/") + "/discover"
+	refreshInterval := ""
+	if p.refreshInterval > 0 {
+		refreshInterval = model.Duration(p.refreshInterval).String()
+	}
+
+	var v any
+	switch format {
+	case genconfigFormatPrometheus:
+		if p.bearerTokenSecret != "" {
+			return fmt.Errorf("the Secret of bearer token is only for %s format. use the file of bearer token instead", genconfigFormatOperator)
+		}
+		// the tenant needs the token both for discover endpoint and for proxy endpoint
+		var auth *promAuthorization
+		if p.bearerTokenFile != "" {
+			auth = &promAuthorization{Type: "Bearer", CredentialsFile: p.bearerTokenFile}
+		}
+		config := &promScrapeConfig{
+			JobName:       p.jobName,
+			Authorization: auth,
+			HTTPSDConfigs: []*promHTTPSDConfig{{URL: discoverURL, RefreshInterval: refreshInterval, Authorization: auth}},
+		}
+		for _, r := range p.relabelRules() {
+			config.RelabelConfigs = append(config.RelabelConfigs, &promRelabel{
+				SourceLabels: r.sourceLabels,
+				Regex:        r.regex,
+				TargetLabel:  r.targetLabel,
+				Replacement:  r.replacement,
+				Action:       r.action,
+			})
+		}
+		v = map[string]any{"scrape_configs": []*promScrapeConfig{config}}
+	case genconfigFormatOperator:
+		if p.bearerTokenFile != "" {
+			return fmt.Errorf("the file of bearer token is only for %s format. use the Secret of bearer token instead", genconfigFormatPrometheus)
+		}
+		var auth *operatorAuthorization
+		if p.bearerTokenSecret != "" {
+			name, key, ok := strings.Cut(p.bearerTokenSecret, ":")
+			if !ok || name == "" || key == "" {
+				return fmt.Errorf("the Secret of bearer token must be in the form of `<name>:<key>`. got: %s", p.bearerTokenSecret)
+			}
+			auth = &operatorAuthorization{Type: "Bearer", Credentials: operatorSecretKeySelector{Name: name, Key: key}}
+		}
+		config := &operatorScrapeConfig{
+			APIVersion: "monitoring.coreos.com/v1alpha1",
+			Kind:       "ScrapeConfig",
+			Metadata:   operatorMetadata{Name: p.jobName, Namespace: namespace},
+			Spec: operatorScrapeConfigSpec{
+				JobName:       p.jobName,
+				Authorization: auth,
+				HTTPSDConfigs: []*operatorHTTPSDConfig{{URL: discoverURL, RefreshInterval: refreshInterval, Authorization: auth}},
+			},
+		}
+		for _, r := range p.relabelRules() {
+			config.Spec.Relabelings = append(config.Spec.Relabelings, &operatorRelabel{
+				SourceLabels: r.sourceLabels,
+				Regex:        r.regex,
+				TargetLabel:  r.targetLabel,
+				Replacement:  r.replacement,
+				Action:       r.action,
+			})
+		}
+		v = config
+	default:
+		return fmt.Errorf("unknown format `%s`. (candidates: prometheus, operator)", format)
+	}
+
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	defer enc.Close()
+	return enc.Encode(v)
+}
+
+// genconfigCmd prints the scrape config of Prometheus to discover the targets from prommux.
+var genconfigCmd = &cobra.Command{
+	Use:   "genconfig",
+	Short: "Print the scrape config of Prometheus for prommux",
+	Long: `Print the scrape config of Prometheus, or the ScrapeConfig manifest of Prometheus Operator,
+which discovers the targets from prommux by http_sd_configs and relabels them.
+
+The relabeling depends on --include-labels and --regexp-labels, which must be the same as the ones of the server.
+With --from-server, they are read from the configuration API of the running prommux at --url instead.
+
+When prommux has tenants, Prometheus needs the bearer token of a tenant both for discovery and for scraping.
+Give the file of the token on Prometheus by --scrape-bearer-token-file, or the Secret of the token
+by --scrape-bearer-token-secret for the operator format.
+--bearer-token and --bearer-token-file are only for --from-server to read the configuration.`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		params := &genconfigParams{
+			jobName:           genconfigJobName,
+			url:               genconfigURL,
+			refreshInterval:   genconfigRefreshInterval,
+			includeLabels:     includeDockerLabels,
+			dropScrapeURL:     genconfigDropScrapeURL,
+			bearerTokenFile:   genconfigBearerTokenFile,
+			bearerTokenSecret: genconfigBearerTokenSecret,
+		}
+		regexpLabels := regexpDockerLabels
+
+		if genconfigFromServer {
+			c, err := client.New(genconfigURL, client.WithHTTPConfig(&genconfigHTTPConfig))
+			if err != nil {
+				return fmt.Errorf("failed to create client: %w", err)
+			}
+			config, err := c.Config(cmd.Context())
+			if err != nil {
+				return fmt.Errorf("failed to get the configuration of prommux: %w", err)
+			}
+			if config.DiscovererParams != nil {
+				params.includeLabels = config.DiscovererParams.IncludeDockerLabels
+				regexpLabels = config.DiscovererParams.RegexpDockerLabels
+			}
+		}
+		if regexpLabels != "" {
+			var err error
+			params.regexpLabels, err = regexp.Compile(regexpLabels)
+			if err != nil {
+				return fmt.Errorf("failed to compile regexp of labels: %w", err)
+			}
+		}
+
+		return writeScrapeConfig(cmd.OutOrStdout(), params, genconfigFormat, genconfigNamespace)
+	},
+}
+
+var (
+	genconfigJobName, genconfigURL, genconfigFormat, genconfigNamespace string
+	genconfigBearerTokenFile, genconfigBearerTokenSecret                string
+	genconfigRefreshInterval                                            time.Duration
+	genconfigFromServer, genconfigDropScrapeURL                         bool
+	genconfigHTTPConfig                                                 client.HTTPConfig
+)
+
+func init() {
+	genconfigCmd.Flags().StringVar(&genconfigJobName, "job-name", "prommux", "the name of the scrape job, and of the ScrapeConfig manifest")
+	genconfigCmd.Flags().StringVarP(&genconfigURL, "url", "u", "http://localhost:11298", "the URL for Prometheus to reach prommux")
+	genconfigCmd.Flags().StringVar(&genconfigFormat, "format", genconfigFormatPrometheus, "the output format (prometheus, operator)")
+	genconfigCmd.Flags().StringVar(&genconfigNamespace, "namespace", "", "the namespace of the ScrapeConfig manifest")
+	genconfigCmd.Flags().DurationVar(&genconfigRefreshInterval, "refresh-interval", 30*time.Second, "the interval for Prometheus to refresh the targets")
+	genconfigCmd.Flags().BoolVarP(&includeDockerLabels, "include-labels", "i", false, "whether prommux includes the labels retrieved by Docker API. they are promoted to the labels of the targets.")
+	genconfigCmd.Flags().StringVarP(&regexpDockerLabels, "regexp-labels", "r", "", "regexp to filter Docker labels on prommux")
+	genconfigCmd.Flags().BoolVar(&genconfigFromServer, "from-server", false, "whether to read --include-labels and --regexp-labels from the running prommux at --url")
+	genconfigCmd.Flags().BoolVar(&genconfigDropScrapeURL, "drop-scrape-url", false, "whether to drop the label of the URL of the exporter after relabeling")
+	genconfigCmd.Flags().StringVar(&genconfigBearerTokenFile, "scrape-bearer-token-file", "", "the file of the bearer token of a tenant on Prometheus, to discover and scrape the targets. for prometheus format.")
+	genconfigCmd.Flags().StringVar(&genconfigBearerTokenSecret, "scrape-bearer-token-secret", "", "the Secret of the bearer token of a tenant in the form of `<name>:<key>`, to discover and scrape the targets. for operator format.")
+	addHTTPClientFlags(genconfigCmd, &genconfigHTTPConfig)
+	bindFlagSources(genconfigCmd)
+	rootCmd.AddCommand(genconfigCmd)
+}
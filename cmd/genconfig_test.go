@@ -0,0 +1,136 @@
+package cmd
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+
+	"github.com/prometheus/common/config"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteScrapeConfig(t *testing.T) {
+	params := &genconfigParams{
+		jobName:       "prommux",
+		url:           "http://prommux:11298/",
+		includeLabels: true,
+		regexpLabels:  regexp.MustCompile("label_app"),
+		dropScrapeURL: true,
+	}
+	var buf bytes.Buffer
+	err := writeScrapeConfig(&buf, params, genconfigFormatPrometheus, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the relabel configs are validated by Prometheus on unmarshaling
+	var cfg struct {
+		ScrapeConfigs []struct {
+			JobName       string `yaml:"job_name"`
+			HTTPSDConfigs []struct {
+				URL string `yaml:"url"`
+			} `yaml:"http_sd_configs"`
+			RelabelConfigs []*relabel.Config `yaml:"relabel_configs"`
+		} `yaml:"scrape_configs"`
+	}
+	err = yaml.Unmarshal(buf.Bytes(), &cfg)
+	if err != nil {
+		t.Fatalf("failed to load the generated config: %s\n%s", err, buf.String())
+	}
+	if len(cfg.ScrapeConfigs) != 1 {
+		t.Fatalf("unexpected number of scrape configs: %d", len(cfg.ScrapeConfigs))
+	}
+	sc := cfg.ScrapeConfigs[0]
+	if sc.JobName != "prommux" {
+		t.Errorf("unexpected job name: %s", sc.JobName)
+	}
+	if len(sc.HTTPSDConfigs) != 1 || sc.HTTPSDConfigs[0].URL != "http://prommux:11298/discover" {
+		t.Errorf("unexpected http_sd_configs: %+v", sc.HTTPSDConfigs)
+	}
+	var actions []string
+	for _, r := range sc.RelabelConfigs {
+		actions = append(actions, string(r.Action))
+	}
+	// the container name is excluded by the regexp of labels
+	want := []string{"replace", "labelmap", "labeldrop"}
+	if len(actions) != len(want) {
+		t.Fatalf("unexpected relabel actions. got: %v, want: %v", actions, want)
+	}
+	for i := range want {
+		if actions[i] != want[i] {
+			t.Errorf("unexpected relabel actions. got: %v, want: %v", actions, want)
+		}
+	}
+
+	err = writeScrapeConfig(&buf, params, "unknown", "")
+	if err == nil {
+		t.Error("unknown format must be rejected")
+	}
+}
+
+func TestWriteScrapeConfigBearerToken(t *testing.T) {
+	t.Run("prometheus", func(t *testing.T) {
+		params := &genconfigParams{jobName: "prommux", url: "http://prommux:11298", bearerTokenFile: "/etc/prometheus/prommux-token"}
+		var buf bytes.Buffer
+		err := writeScrapeConfig(&buf, params, genconfigFormatPrometheus, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cfg struct {
+			ScrapeConfigs []struct {
+				Authorization *config.Authorization `yaml:"authorization"`
+				HTTPSDConfigs []struct {
+					Authorization *config.Authorization `yaml:"authorization"`
+				} `yaml:"http_sd_configs"`
+			} `yaml:"scrape_configs"`
+		}
+		err = yaml.Unmarshal(buf.Bytes(), &cfg)
+		if err != nil {
+			t.Fatalf("failed to load the generated config: %s\n%s", err, buf.String())
+		}
+		sc := cfg.ScrapeConfigs[0]
+		for name, auth := range map[string]*config.Authorization{"scrape": sc.Authorization, "http_sd_configs": sc.HTTPSDConfigs[0].Authorization} {
+			if auth == nil || auth.Type != "Bearer" || auth.CredentialsFile != params.bearerTokenFile {
+				t.Errorf("unexpected authorization of %s: %+v", name, auth)
+			}
+		}
+	})
+
+	t.Run("operator", func(t *testing.T) {
+		params := &genconfigParams{jobName: "prommux", url: "http://prommux:11298", bearerTokenSecret: "prommux:token"}
+		var buf bytes.Buffer
+		err := writeScrapeConfig(&buf, params, genconfigFormatOperator, "monitoring")
+		if err != nil {
+			t.Fatal(err)
+		}
+		var manifest operatorScrapeConfig
+		err = yaml.Unmarshal(buf.Bytes(), &manifest)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := &operatorAuthorization{Type: "Bearer", Credentials: operatorSecretKeySelector{Name: "prommux", Key: "token"}}
+		for name, auth := range map[string]*operatorAuthorization{"spec": manifest.Spec.Authorization, "httpSDConfigs": manifest.Spec.HTTPSDConfigs[0].Authorization} {
+			if auth == nil || *auth != *want {
+				t.Errorf("unexpected authorization of %s: %+v", name, auth)
+			}
+		}
+	})
+
+	for name, tc := range map[string]struct {
+		params *genconfigParams
+		format string
+	}{
+		"file for operator":      {params: &genconfigParams{bearerTokenFile: "/token"}, format: genconfigFormatOperator},
+		"secret for prometheus":  {params: &genconfigParams{bearerTokenSecret: "prommux:token"}, format: genconfigFormatPrometheus},
+		"secret without key":     {params: &genconfigParams{bearerTokenSecret: "prommux"}, format: genconfigFormatOperator},
+		"secret with empty name": {params: &genconfigParams{bearerTokenSecret: ":token"}, format: genconfigFormatOperator},
+	} {
+		t.Run(name, func(t *testing.T) {
+			err := writeScrapeConfig(&bytes.Buffer{}, tc.params, tc.format, "")
+			if err == nil {
+				t.Error("error must be returned")
+			}
+		})
+	}
+}
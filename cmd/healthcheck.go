@@ -1,6 +1,8 @@
 package cmd
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log/slog"
 	"net/http"
@@ -9,12 +11,64 @@ import (
 	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/client"
+	"github.com/xruins/prommux/pkg/handler"
 )
 
-// rootCmd represents the base command when called without any subcommands
+// exit codes of healthcheck command.
+const (
+	// exitCodeHealthy indicates that the server is healthy and all the checks passed.
+	exitCodeHealthy = 0
+	// exitCodeError indicates that the command itself failed. e.g. invalid flags.
+	exitCodeError = 1
+	// exitCodeUnreachable indicates that the server could not be reached.
+	exitCodeUnreachable = 2
+	// exitCodeUnhealthy indicates that the health endpoint returned non-OK status code.
+	exitCodeUnhealthy = 3
+	// exitCodeDegraded indicates that the server is healthy but some of the additional checks failed.
+	exitCodeDegraded = 4
+)
+
+// exitError is an error to exit the process with the specific code.
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// exitCode returns the code to exit the process with for err returned by a command.
+func exitCode(err error) int {
+	if err == nil {
+		return exitCodeHealthy
+	}
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+	return exitCodeError
+}
+
 var healthCheckCmd = &cobra.Command{
 	Use:   "healthcheck",
-	Short: "A server for HTTP service-discovery and reverse-proxy for Prometheus exporters",
+	Short: "Check health of a running prommux",
+	Long: `Check health of a running prommux.
+
+It requests the health endpoint, and then the status API if any of --min-targets,
+--max-discovery-age and --require-hash is specified.
+
+Exit codes:
+  0  healthy: the health endpoint returned OK and all the checks passed
+  1  error: the command failed by itself. e.g. invalid flags
+  2  unreachable: failed to request the server
+  3  unhealthy: the health endpoint returned non-OK status code
+  4  degraded: the server is healthy but some of the additional checks failed`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		ctx := cmd.Context()
 		level, err := setLogLevel(logLevel)
@@ -24,8 +78,9 @@ var healthCheckCmd = &cobra.Command{
 
 		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 
-		client := http.Client{
-			Timeout: healthcheckTimeout,
+		httpClient, err := healthcheckHTTPConfig.NewHTTPClient()
+		if err != nil {
+			return fmt.Errorf("failed to create HTTP client: %w", err)
 		}
 		var u *url.URL
 		if paramURL != "" {
@@ -36,46 +91,126 @@ var healthCheckCmd = &cobra.Command{
 			}
 		} else {
 			u = &url.URL{
-				Scheme: "http",
+				Scheme: healthcheckScheme,
 				Host:   fmt.Sprintf("%s:%d", bindAddress, port),
-				Path:   "/-/health",
+				Path:   "/-/ready",
 			}
 		}
+		// the error is reported by exit code from here
+		cmd.SilenceUsage = true
+
 		logger.DebugContext(ctx, "generated request URL", slog.String("url", u.String()))
-		req := &http.Request{
-			Method: http.MethodGet,
-			URL:    u,
-			Header: http.Header{
-				"User-Agent": []string{"Prommux"},
-			},
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+		if err != nil {
+			return fmt.Errorf("failed to create request: %w", err)
 		}
-		req = req.WithContext(ctx)
-		resp, err := client.Do(req)
+		resp, err := httpClient.Do(req)
 		if err != nil {
 			logger.Error("failed to request healthcheck API", "error", err)
-			os.Exit(1)
+			return &exitError{code: exitCodeUnreachable, err: err}
 		}
 		defer resp.Body.Close()
 
 		if resp.StatusCode != http.StatusOK {
+			// report the components which are not ready, if the response describes them
+			var health handler.ResponseHealth
+			if json.NewDecoder(resp.Body).Decode(&health) == nil {
+				for name, c := range health.Components {
+					if c.Status != "ok" {
+						logger.Error(
+							"the component is not ready",
+							slog.String("component", name),
+							slog.String("state", c.State.String()),
+							slog.String("message", c.Message),
+						)
+					}
+				}
+			}
 			logger.Error("the healthcheck API returned non-OK status code", slog.Int("code", resp.StatusCode))
-			return err
+			return &exitError{
+				code: exitCodeUnhealthy,
+				err:  fmt.Errorf("the healthcheck API returned non-OK status code: %d", resp.StatusCode),
+			}
 		}
+
+		if healthcheckMinTargets > 0 || healthcheckMaxDiscoveryAge > 0 || len(healthcheckRequireHashes) > 0 {
+			baseURL := &url.URL{Scheme: u.Scheme, Host: u.Host}
+			c, err := client.New(baseURL.String(), client.WithHTTPClient(httpClient))
+			if err != nil {
+				return fmt.Errorf("failed to create client: %w", err)
+			}
+			status, err := c.Status(ctx)
+			if err != nil {
+				logger.Error("failed to request status API", "error", err)
+				return &exitError{code: exitCodeUnreachable, err: err}
+			}
+
+			var failures []error
+			if n := len(status.Targets); n < healthcheckMinTargets {
+				failures = append(failures, fmt.Errorf("the number of targets %d is less than %d", n, healthcheckMinTargets))
+			}
+			if healthcheckMaxDiscoveryAge > 0 {
+				if status.LastDiscovery == nil {
+					failures = append(failures, errors.New("discovery has never completed"))
+				} else if age := time.Since(*status.LastDiscovery); age > healthcheckMaxDiscoveryAge {
+					failures = append(failures, fmt.Errorf("the last discovery is %s old, older than %s", age.Truncate(time.Second), healthcheckMaxDiscoveryAge))
+				}
+			}
+			hashes := make(map[string]struct{}, len(status.Targets))
+			for _, t := range status.Targets {
+				hashes[t.Hash] = struct{}{}
+			}
+			for _, hash := range healthcheckRequireHashes {
+				if _, ok := hashes[hash]; !ok {
+					failures = append(failures, fmt.Errorf("the target `%s` is missing", hash))
+				}
+			}
+
+			if len(failures) > 0 {
+				err := errors.Join(failures...)
+				logger.Error("the healthcheck is degraded", "error", err)
+				return &exitError{code: exitCodeDegraded, err: err}
+			}
+		}
+
 		logger.Info("healthcheck passed")
 		return nil
 	},
 }
 
 var (
-	paramURL           string
-	healthcheckTimeout time.Duration
+	paramURL                   string
+	healthcheckScheme          string
+	healthcheckHTTPConfig      client.HTTPConfig
+	healthcheckMinTargets      int
+	healthcheckMaxDiscoveryAge time.Duration
+	healthcheckRequireHashes   []string
 )
 
+// addHTTPClientFlags adds the flags to configure TLS and authentication for the API of prommux to cmd.
+func addHTTPClientFlags(cmd *cobra.Command, config *client.HTTPConfig) {
+	cmd.Flags().StringVar(&config.CAFile, "ca-file", "", "the CA certificate file to verify the server")
+	cmd.Flags().StringVar(&config.CertFile, "cert-file", "", "the client certificate file for TLS authentication")
+	cmd.Flags().StringVar(&config.KeyFile, "key-file", "", "the client key file for TLS authentication")
+	cmd.Flags().StringVar(&config.ServerName, "server-name", "", "the server name to verify the certificate of the server")
+	cmd.Flags().BoolVar(&config.InsecureSkipVerify, "insecure-skip-verify", false, "whether to skip verifying the certificate of the server")
+	cmd.Flags().StringVar(&config.Username, "username", "", "the username for basic authentication")
+	cmd.Flags().StringVar(&config.Password, "password", "", "the password for basic authentication")
+	cmd.Flags().StringVar(&config.BearerToken, "bearer-token", "", "the bearer token for authentication")
+	cmd.Flags().StringVar(&config.BearerTokenFile, "bearer-token-file", "", "the file to read the bearer token for authentication")
+}
+
 func init() {
 	healthCheckCmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "the severity for logging (error, info, warn, debug)")
-	healthCheckCmd.Flags().StringVarP(&paramURL, "url", "u", "", "the url to check health on. if specified, `-h` and `-p` options will be ignored.")
+	healthCheckCmd.Flags().StringVarP(&paramURL, "url", "u", "", "the url to check health on. if specified, `-a`, `-p` and `--scheme` options will be ignored.")
 	healthCheckCmd.Flags().StringVarP(&bindAddress, "address", "a", "127.0.0.1", "the address to check health on")
 	healthCheckCmd.Flags().IntVarP(&port, "port", "p", 11298, "the port to check health on")
-	healthCheckCmd.Flags().DurationVarP(&healthcheckTimeout, "timeout", "t", 30*time.Second, "the timeout to poll health")
+	healthCheckCmd.Flags().StringVar(&healthcheckScheme, "scheme", "http", "the scheme to check health on (http, https)")
+	healthCheckCmd.Flags().DurationVarP(&healthcheckHTTPConfig.Timeout, "timeout", "t", 30*time.Second, "the timeout to poll health")
+	addHTTPClientFlags(healthCheckCmd, &healthcheckHTTPConfig)
+	healthCheckCmd.Flags().IntVar(&healthcheckMinTargets, "min-targets", 0, "the minimum number of targets. degraded if less than it.")
+	healthCheckCmd.Flags().DurationVar(&healthcheckMaxDiscoveryAge, "max-discovery-age", 0, "the maximum age of the last discovery. degraded if older than it.")
+	healthCheckCmd.Flags().StringArrayVar(&healthcheckRequireHashes, "require-hash", nil, "the hash of target which must be present. degraded if missing. can be specified multiple times.")
+	bindFlagSources(healthCheckCmd)
 	rootCmd.AddCommand(healthCheckCmd)
 }
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+// resetFlags resets the flags of cmd to their defaults, since the flags of rootCmd are shared among executions.
+func resetFlags(t *testing.T, cmd *cobra.Command) {
+	t.Helper()
+	cmd.Flags().VisitAll(func(f *pflag.Flag) {
+		var err error
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			err = v.Replace(nil)
+		} else {
+			err = f.Value.Set(f.DefValue)
+		}
+		if err != nil {
+			t.Fatalf("failed to reset flag `%s`: %s", f.Name, err)
+		}
+		f.Changed = false
+	})
+}
+
+// cleanupCommand resets the flags of cmd and the contexts of cmd and rootCmd after t,
+// since a subcommand inherits the context of rootCmd given by ExecuteContext in another test.
+func cleanupCommand(t *testing.T, cmd *cobra.Command) {
+	t.Cleanup(func() {
+		resetFlags(t, cmd)
+		cmd.SetContext(nil)
+		rootCmd.SetContext(nil)
+	})
+}
+
+// newHealthServer starts a fake prommux which responds readiness with code and status API with status.
+func newHealthServer(t *testing.T, code int, status *handler.ResponseStatus) string {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/-/ready", func(w http.ResponseWriter, r *http.Request) {
+		health := &handler.ResponseHealth{Status: "ok"}
+		if code != http.StatusOK {
+			health = &handler.ResponseHealth{
+				Status: "fail",
+				Components: map[string]*handler.ResponseHealthComponent{
+					"discovery": {Status: "fail", State: handler.StateFailed, Message: "failed to list containers"},
+				},
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(health)
+	})
+	mux.HandleFunc("/status", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	})
+	s := httptest.NewServer(mux)
+	t.Cleanup(s.Close)
+	return s.URL
+}
+
+func TestHealthCheckExitCode(t *testing.T) {
+	recent := time.Now().Add(-time.Second)
+	old := time.Now().Add(-time.Hour)
+	status := &handler.ResponseStatus{
+		Targets:       []*handler.ResponseStatusTarget{{Hash: "abc"}, {Hash: "def"}},
+		LastDiscovery: &recent,
+	}
+	healthy := newHealthServer(t, http.StatusOK, status)
+	unhealthy := newHealthServer(t, http.StatusServiceUnavailable, status)
+	stale := newHealthServer(t, http.StatusOK, &handler.ResponseStatus{Targets: status.Targets, LastDiscovery: &old})
+	undiscovered := newHealthServer(t, http.StatusOK, &handler.ResponseStatus{})
+	unreachable := fmt.Sprintf("http://127.0.0.1:%d", freePort(t))
+
+	for name, tc := range map[string]struct {
+		args []string
+		want int
+	}{
+		"healthy":             {args: []string{"--url", healthy + "/-/ready"}, want: exitCodeHealthy},
+		"healthy with checks": {args: []string{"--url", healthy + "/-/ready", "--min-targets", "2", "--max-discovery-age", "1m", "--require-hash", "abc"}, want: exitCodeHealthy},
+		"invalid log level":   {args: []string{"--url", healthy + "/-/ready", "--log-level", "verbose"}, want: exitCodeError},
+		"invalid http config": {args: []string{"--url", healthy + "/-/ready", "--bearer-token", "token", "--username", "user"}, want: exitCodeError},
+		"unreachable":         {args: []string{"--url", unreachable + "/-/ready"}, want: exitCodeUnreachable},
+		"unready":             {args: []string{"--url", unhealthy + "/-/ready"}, want: exitCodeUnhealthy},
+		"unready with checks": {args: []string{"--url", unhealthy + "/-/ready", "--min-targets", "1"}, want: exitCodeUnhealthy},
+		"too few targets":     {args: []string{"--url", healthy + "/-/ready", "--min-targets", "3"}, want: exitCodeDegraded},
+		"stale discovery":     {args: []string{"--url", stale + "/-/ready", "--max-discovery-age", "1m"}, want: exitCodeDegraded},
+		"discovery never ran": {args: []string{"--url", undiscovered + "/-/ready", "--max-discovery-age", "1m"}, want: exitCodeDegraded},
+		"missing hash":        {args: []string{"--url", healthy + "/-/ready", "--require-hash", "abc", "--require-hash", "xyz"}, want: exitCodeDegraded},
+		"multiple degraded":   {args: []string{"--url", stale + "/-/ready", "--min-targets", "3", "--max-discovery-age", "1m"}, want: exitCodeDegraded},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Cleanup(func() { resetFlags(t, healthCheckCmd) })
+			rootCmd.SetArgs(append([]string{"healthcheck", "--log-level", "error"}, tc.args...))
+
+			err := rootCmd.Execute()
+			if got := exitCode(err); got != tc.want {
+				t.Errorf("unexpected exit code. want: %d, got: %d, err: %v", tc.want, got, err)
+			}
+		})
+	}
+}
+
+func TestExitCode(t *testing.T) {
+	for name, tc := range map[string]struct {
+		err  error
+		want int
+	}{
+		"nil":     {want: exitCodeHealthy},
+		"error":   {err: errors.New("failed"), want: exitCodeError},
+		"exit":    {err: &exitError{code: exitCodeDegraded, err: errors.New("degraded")}, want: exitCodeDegraded},
+		"wrapped": {err: fmt.Errorf("wrapped: %w", &exitError{code: exitCodeUnhealthy, err: errors.New("unhealthy")}), want: exitCodeUnhealthy},
+	} {
+		t.Run(name, func(t *testing.T) {
+			if got := exitCode(tc.err); got != tc.want {
+				t.Errorf("unexpected exit code. want: %d, got: %d", tc.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,110 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/compose"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+// lintComposeCmd validates the labels for prommux in docker-compose files.
+var lintComposeCmd = &cobra.Command{
+	Use:   "lint-compose <file>...",
+	Short: "Validate the labels for prommux in docker-compose files",
+	Long: "Parse docker-compose files and validate every `prommux.*` label of each service, " +
+		"then print the example URLs to scrape rendered from the declared ports. " +
+		"It exits with non-zero status if any problem is found.",
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		out := cmd.OutOrStdout()
+		problems := 0
+		for _, file := range args {
+			n, err := lintComposeFile(out, file)
+			if err != nil {
+				return err
+			}
+			problems += n
+		}
+		if problems > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("found %d problem(s) in prommux labels", problems)
+		}
+		return nil
+	},
+}
+
+// lintComposeFile validates a docker-compose file and returns the number of problems found.
+func lintComposeFile(w io.Writer, file string) (int, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read `%s`: %w", file, err)
+	}
+	project, err := compose.Parse(data)
+	if err != nil {
+		fmt.Fprintf(w, "%s: %s\n", file, err)
+		return 1, nil
+	}
+
+	problems := 0
+	for _, svc := range project.Services {
+		labels := make(map[string]string)
+		positions := make(map[string]*compose.Label)
+		for _, l := range svc.Labels {
+			if strings.HasPrefix(l.Key, handler.DockerLabelPrefix) {
+				labels[l.Key] = l.Value
+				positions[l.Key] = l
+			}
+		}
+		if len(labels) == 0 {
+			continue
+		}
+
+		issues := handler.LintDockerLabels(labels)
+		sort.SliceStable(issues, func(i, j int) bool {
+			return positions[issues[i].Label].Line < positions[issues[j].Label].Line
+		})
+		for _, issue := range issues {
+			pos := positions[issue.Label]
+			fmt.Fprintf(w, "%s:%d:%d: service %q: %s\n", file, pos.Line, pos.Column, svc.Name, issue)
+		}
+		problems += len(issues)
+		if len(issues) > 0 || lintComposeQuiet {
+			continue
+		}
+
+		// print the example URLs to scrape
+		ports := svc.Ports
+		if len(ports) == 0 {
+			ports = []*compose.Port{{Container: lintComposeFallbackPort, Line: svc.Line}}
+		}
+		for _, p := range ports {
+			address := net.JoinHostPort(svc.Name, strconv.Itoa(p.Container))
+			u, err := handler.RenderURLFromDockerLabels(labels, address)
+			if err != nil {
+				fmt.Fprintf(w, "%s:%d: service %q: port %d: %s\n", file, p.Line, svc.Name, p.Container, err)
+				problems++
+				continue
+			}
+			fmt.Fprintf(w, "%s:%d: service %q: port %d -> %s\n", file, p.Line, svc.Name, p.Container, u)
+		}
+	}
+	return problems, nil
+}
+
+var (
+	lintComposeQuiet        bool
+	lintComposeFallbackPort int
+)
+
+func init() {
+	lintComposeCmd.Flags().BoolVarP(&lintComposeQuiet, "quiet", "q", false, "print only the problems, without the example URLs")
+	lintComposeCmd.Flags().IntVar(&lintComposeFallbackPort, "fallback-port", 8080, "the port for the services without ports. must be same as `--docker-port` of server.")
+	rootCmd.AddCommand(lintComposeCmd)
+}
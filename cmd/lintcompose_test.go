@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func writeComposeFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "compose.yml")
+	err := os.WriteFile(path, []byte(content), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLintCompose(t *testing.T) {
+	valid := writeComposeFile(t, `services:
+  web:
+    image: nginx
+    ports:
+      - "8080:80"
+    labels:
+      prommux.metrics_path: /stats
+  db:
+    image: postgres
+`)
+	invalid := writeComposeFile(t, `services:
+  web:
+    image: nginx
+    labels:
+      prommux.scheme: ftp
+      prommux.port: "9100"
+`)
+
+	for name, tc := range map[string]struct {
+		files   []string
+		want    string
+		wantErr bool
+	}{
+		"valid": {
+			files: []string{valid},
+			want:  valid + `:5: service "web": port 80 -> http://web:80/stats` + "\n",
+		},
+		"unknown label": {
+			files: []string{valid, invalid},
+			want: valid + `:5: service "web": port 80 -> http://web:80/stats` + "\n" +
+				invalid + `:5:7: service "web": prommux.scheme: ` + "`ftp` (rendered from `ftp`) is not a valid scheme. (candidates: http, https)\n" +
+				invalid + `:6:7: service "web": prommux.port: unknown label. (candidates: prommux.scheme, prommux.address, prommux.metrics_path)` + "\n",
+			wantErr: true,
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			var out bytes.Buffer
+			rootCmd.SetOut(&out)
+			t.Cleanup(func() { rootCmd.SetOut(nil) })
+			rootCmd.SetArgs(append([]string{"lint-compose"}, tc.files...))
+
+			err := rootCmd.Execute()
+			if tc.wantErr != (err != nil) {
+				t.Errorf("unexpected error. want error: %t, got: %v", tc.wantErr, err)
+			}
+			if diff := cmp.Diff(tc.want, out.String()); diff != "" {
+				t.Errorf("unexpected output (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
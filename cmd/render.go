@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/prometheus/common/model"
+	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/handler"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	outputFormatJSON  = "json"
+	outputFormatYAML  = "yaml"
+	outputFormatTable = "table"
+)
+
+// renderCmd runs discovery once and prints the response of discover API.
+var renderCmd = &cobra.Command{
+	Use:   "render",
+	Short: "Run discovery once and print the result",
+	Long:  "Connect to Docker API once, run discovery with the same flags as `server` and print the response of discover API to stdout",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		level, err := setLogLevel(logLevel)
+		if err != nil {
+			return fmt.Errorf("failed to set log level: %w", err)
+		}
+
+		// stdout is for the result
+		logger := slog.New(slog.NewJSONHandler(os.Stderr, &slog.HandlerOptions{Level: level}))
+
+		configs, err := runDiscoveryOnce(cmd.Context(), logger, func(h *handler.Handler) ([]*handler.StaticConfig, error) {
+			if renderView != "" {
+				return h.ViewStaticConfigs(renderView, renderScheme, renderTargetAddress)
+			}
+			return h.StaticConfigs(renderScheme, renderTargetAddress)
+		})
+		if err != nil {
+			return err
+		}
+
+		return writeStaticConfigs(cmd.OutOrStdout(), configs, renderOutput)
+	},
+}
+
+// runDiscoveryOnce creates Handler by flags, waits for the first discovery and calls fn with it.
+func runDiscoveryOnce[T any](ctx context.Context, logger *slog.Logger, fn func(h *handler.Handler) (T, error)) (T, error) {
+	var zero T
+	params, err := newHandlerParams(logger)
+	if err != nil {
+		return zero, err
+	}
+	h, err := handler.NewHandler(params)
+	if err != nil {
+		return zero, fmt.Errorf("failed to initialize handler: %w", err)
+	}
+
+	runCtx, cancelRun := context.WithCancel(ctx)
+	runErrCh := make(chan error, 1)
+	go func() {
+		runErrCh <- h.Run(runCtx)
+	}()
+	defer func() {
+		cancelRun()
+		<-runErrCh
+	}()
+
+	waitCtx, cancelWait := context.WithTimeout(ctx, discoverTimeout)
+	defer cancelWait()
+	readyCh := make(chan error, 1)
+	go func() {
+		readyCh <- h.WaitReady(waitCtx)
+	}()
+	select {
+	case err := <-readyCh:
+		if err != nil {
+			return zero, err
+		}
+	case err := <-runErrCh:
+		// Run never returns nil before runCtx is canceled
+		runErrCh <- err
+		return zero, fmt.Errorf("discovery exited before the first result: %w", err)
+	}
+
+	return fn(h)
+}
+
+// writeStaticConfigs writes configs to w in the given format.
+func writeStaticConfigs(w io.Writer, configs []*handler.StaticConfig, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(configs)
+	case outputFormatYAML:
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		defer enc.Close()
+		return enc.Encode(configs)
+	case outputFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "TARGET\tMETRICS PATH\tSCRAPE URL\tLABELS")
+		for _, c := range configs {
+			labels := make([]string, 0, len(c.Labels))
+			for k, v := range c.Labels {
+				switch k {
+				case model.MetricsPathLabel, model.SchemeLabel, handler.LabelScrapeURL:
+					continue
+				}
+				labels = append(labels, fmt.Sprintf("%s=%s", k, v))
+			}
+			sort.Strings(labels)
+			fmt.Fprintf(
+				tw, "%s\t%s\t%s\t%s\n",
+				strings.Join(c.Targets, ","),
+				c.Labels[model.MetricsPathLabel],
+				c.Labels[handler.LabelScrapeURL],
+				strings.Join(labels, ","),
+			)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format `%s`. (candidates: json, yaml, table)", format)
+	}
+}
+
+var (
+	renderOutput, renderScheme, renderTargetAddress, renderView string
+)
+
+func init() {
+	addDiscovererFlags(renderCmd)
+	renderCmd.Flags().StringVar(&renderOutput, "output", outputFormatJSON, "the output format (json, yaml, table). yaml is in the format of file_sd.")
+	renderCmd.Flags().StringVar(&renderScheme, "scheme", "http", "the scheme to reach prommux, written in each target")
+	renderCmd.Flags().StringVar(&renderTargetAddress, "target-address", "localhost:11298", "the address to reach prommux, written in each target")
+	renderCmd.Flags().StringVar(&renderView, "view", "", "the name of the view to print instead of all the targets. views are defined in the config file.")
+	bindFlagSources(renderCmd,
+		configSection{key: "views", target: &configViews},
+		configSection{key: "tenants", target: &configTenants},
+	)
+	rootCmd.AddCommand(renderCmd)
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/xruins/prommux/pkg/handler"
+	"gopkg.in/yaml.v3"
+)
+
+func TestWriteStaticConfigs(t *testing.T) {
+	configs := []*handler.StaticConfig{
+		{
+			Targets: []string{"prommux:11298"},
+			Labels: model.LabelSet{
+				model.MetricsPathLabel: "/proxy/abc",
+				model.SchemeLabel:      "http",
+				handler.LabelScrapeURL: "http://172.18.0.2:9100/metrics",
+				"job":                  "node",
+				"env":                  "prod",
+			},
+		},
+		{
+			Targets: []string{"prommux:11298"},
+			Labels: model.LabelSet{
+				model.MetricsPathLabel: "/proxy/def",
+				handler.LabelScrapeURL: "http://172.18.0.3:8080/metrics",
+			},
+		},
+	}
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeStaticConfigs(&buf, configs, outputFormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*handler.StaticConfig
+		err = json.Unmarshal(buf.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("output is not JSON: %s", err)
+		}
+		if diff := cmp.Diff(configs, got); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("yaml", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeStaticConfigs(&buf, configs, outputFormatYAML)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*handler.StaticConfig
+		err = yaml.Unmarshal(buf.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("output is not YAML: %s", err)
+		}
+		if diff := cmp.Diff(configs, got); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeStaticConfigs(&buf, configs, outputFormatTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "" +
+			"TARGET         METRICS PATH  SCRAPE URL                      LABELS\n" +
+			"prommux:11298  /proxy/abc    http://172.18.0.2:9100/metrics  env=prod,job=node\n" +
+			"prommux:11298  /proxy/def    http://172.18.0.3:8080/metrics  \n"
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		err := writeStaticConfigs(&bytes.Buffer{}, configs, "xml")
+		if err == nil {
+			t.Error("error must be returned for unknown format")
+		}
+	})
+}
@@ -8,7 +8,9 @@ import (
 	"log/slog"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
 	"github.com/prometheus/prometheus/discovery/moby"
@@ -55,11 +57,99 @@ func setLogLevel(level string) (slog.Level, error) {
 	}
 }
 
+// newHandlerParams creates HandlerParams from the flags shared by the commands which run discovery.
+func newHandlerParams(logger *slog.Logger) (*handler.HandlerParams, error) {
+	mobyFilter, err := filterStringToMobyFilter(filter)
+	if err != nil {
+		return nil, fmt.Errorf("failed to convert the value of `filter`: %w", err)
+	}
+
+	var fileSD *handler.FileSDParams
+	if fileSDPath != "" {
+		if fileSDTargetAddress == "" {
+			// prommux cannot tell how Prometheus reaches it
+			return nil, errors.New("`file-sd-target-address` must be specified with `file-sd-path`")
+		}
+		fileSD = &handler.FileSDParams{
+			Path:           fileSDPath,
+			Format:         fileSDFormat,
+			Scheme:         fileSDScheme,
+			Address:        fileSDTargetAddress,
+			SplitByProject: fileSDSplitByProject,
+		}
+	}
+
+	return &handler.HandlerParams{
+		Logger:                 *logger,
+		ProxyTimeout:           proxyTimeout,
+		AdditionalLabels:       additionalLabels,
+		FlagSources:            flagSources,
+		DiscoverGzip:           discoverGzip,
+		ShardKey:               shardKey,
+		Views:                  configViews,
+		Tenants:                configTenants,
+		MetricsBearerToken:     metricsBearerToken,
+		MetricsBearerTokenFile: metricsBearerTokenFile,
+		ProxyMetricsMaxTargets: proxyMetricsMaxTargets,
+		FileSD:                 fileSD,
+		DiscovererParams: &handler.DiscovererParams{
+			Host:                dockerAddress,
+			Port:                dockerPort,
+			DiscovererTimeout:   discoverTimeout,
+			IncludeDockerLabels: includeDockerLabels,
+			RegexpDockerLabels:  regexpDockerLabels,
+			RefreshInterval:     dockerRefreshInterval,
+			HostNetworkingHost:  hostNetworkingHost,
+			Filter:              mobyFilter,
+			StaleFactor:         discoveryStaleFactor,
+		},
+	}, nil
+}
+
+// logStateTransitions logs the transitions of the states of the components of h until ctx is done.
+func logStateTransitions(ctx context.Context, logger *slog.Logger, h *handler.Handler) {
+	ch, unsubscribe := h.SubscribeStates()
+	defer unsubscribe()
+	var prev handler.ComponentStatuses
+	for {
+		select {
+		case statuses := <-ch:
+			for component, status := range statuses {
+				if old, ok := prev[component]; ok && old.State == status.State {
+					continue
+				}
+				level := slog.LevelInfo
+				if status.State == handler.StateDegraded || status.State == handler.StateFailed {
+					level = slog.LevelWarn
+				}
+				logger.Log(
+					ctx, level, "the state of component changed",
+					slog.String("component", component),
+					slog.String("state", status.State.String()),
+					slog.String("message", status.Message),
+				)
+			}
+			prev = statuses
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
 // serverCmd represents the base command when called without any subcommands
 var serverCmd = &cobra.Command{
 	Use:   "server",
 	Short: "Launch server",
-	Long:  "Launch the server for HTTP service-discovery and reverse-proxy for Prometheus exporters",
+	Long: `Launch the server for HTTP service-discovery and reverse-proxy for Prometheus exporters
+
+The named views of service discovery are defined in "views" section of the config file, and served at /discover/{name}.
+Each view has "name", "selector", "include_docker_labels", "regexp_docker_labels", "additional_labels" and "relabel_configs".
+
+The tenants are defined in "tenants" section of the config file. Each tenant has "name", "bearer_token" (or "bearer_token_file")
+and "selector" of its containers. Once any tenant is defined, every request except health checks and /metrics
+must have the bearer token of a tenant, and sees and scrapes only the targets of the tenant.
+/metrics is not restricted by tenants, and exposes the names of tenants and the labels of the containers of all the tenants.
+Set --metrics-bearer-token (or --metrics-bearer-token-file) to require a separate token for it.`,
 	RunE: func(cmd *cobra.Command, args []string) error {
 		level, err := setLogLevel(logLevel)
 		if err != nil {
@@ -68,38 +158,30 @@ var serverCmd = &cobra.Command{
 
 		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: level}))
 
-		mobyFilter, err := filterStringToMobyFilter(filter)
+		params, err := newHandlerParams(logger)
 		if err != nil {
-			return fmt.Errorf("failed to convert the value of `filter`: %w", err)
-		}
-
-		params := &handler.HandlerParams{
-			Logger:           *logger,
-			ProxyTimeout:     proxyTimeout,
-			AdditionalLabels: additionalLabels,
-			DiscovererParams: &handler.DiscovererParams{
-				Host:                dockerAddress,
-				Port:                dockerPort,
-				DiscovererTimeout:   discoverTimeout,
-				IncludeDockerLabels: includeDockerLabels,
-				RegexpDockerLabels:  regexpDockerLabels,
-				RefreshInterval:     dockerRefreshInterval,
-				HostNetworkingHost:  hostNetworkingHost,
-				Filter:              mobyFilter,
-			},
+			return err
 		}
 		r, err := handler.NewHandler(params)
 		if err != nil {
 			return fmt.Errorf("failed to initialize handler: %w", err)
 		}
-		ctx := context.Background()
+		ctx, stop := signal.NotifyContext(cmd.Context(), syscall.SIGINT, syscall.SIGTERM)
+		defer stop()
+
+		// the background tasks keep running while draining, and are stopped after the listener.
+		runCtx, cancelRun := context.WithCancel(context.WithoutCancel(ctx))
+		defer cancelRun()
 		runErrCh := make(chan error, 1)
+		runDoneCh := make(chan struct{})
 		go func() {
-			err := r.Run(ctx)
+			defer close(runDoneCh)
+			err := r.Run(runCtx)
 			if err != nil {
 				runErrCh <- fmt.Errorf("background task exited with an error: %w", err)
 			}
 		}()
+		go logStateTransitions(runCtx, logger, r)
 		serverErrCh := make(chan error, 1)
 		mux := http.NewServeMux()
 		mux.Handle("/", r.NewRouter())
@@ -108,7 +190,7 @@ var serverCmd = &cobra.Command{
 		server := &http.Server{Addr: fmt.Sprintf("%s:%d", bindAddress, port), Handler: handler}
 		go func() {
 			err := server.ListenAndServe()
-			if err != nil {
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
 				serverErrCh <- fmt.Errorf("background task exited with an error: %w", err)
 			}
 		}()
@@ -119,7 +201,52 @@ var serverCmd = &cobra.Command{
 			return err
 		case err := <-serverErrCh:
 			return err
+		case <-ctx.Done():
+		}
+		// the second signal cuts the drain period short instead of killing the process.
+		sigCh := make(chan os.Signal, 1)
+		signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+		defer signal.Stop(sigCh)
+		stop()
+
+		// 1. flip health to not-ready so that load balancers and Prometheus stop sending new requests.
+		logger.Info("shutting down prommux", "drain_period", drainPeriod.String(), "shutdown_timeout", shutdownTimeout.String())
+		r.SetShuttingDown()
+		if drainPeriod > 0 {
+			logger.Info("waiting for drain period to elapse", "drain_period", drainPeriod.String())
+			drainTimer := time.NewTimer(drainPeriod)
+			select {
+			case <-drainTimer.C:
+			case sig := <-sigCh:
+				drainTimer.Stop()
+				logger.Warn("drain period is cut short by signal", "signal", sig.String())
+			}
 		}
+
+		// 2. stop the listener and wait for in-flight proxy requests to finish.
+		shutdownCtx, cancelShutdown := context.WithTimeout(context.WithoutCancel(ctx), shutdownTimeout)
+		defer cancelShutdown()
+		err = server.Shutdown(shutdownCtx)
+		if err != nil {
+			logger.Warn("failed to shut down HTTP server gracefully", "error", err)
+			server.Close()
+		}
+
+		// 3. stop the discoverer and the background tasks.
+		cancelRun()
+		select {
+		case <-runDoneCh:
+		case <-shutdownCtx.Done():
+			logger.Warn("timed out waiting for background tasks to stop")
+		}
+		select {
+		case err := <-runErrCh:
+			return err
+		default:
+		}
+
+		logger.Info("prommux has been shut down")
+		return nil
 	},
 }
 
@@ -129,33 +256,65 @@ func Execute() {
 	err := rootCmd.Execute()
 	if err != nil {
 		slog.Default().Error("an error occured", "error", err)
-		os.Exit(1)
+		os.Exit(exitCode(err))
 	}
 }
 
 var (
-	port, dockerPort int
+	port, dockerPort, proxyMetricsMaxTargets int
 	bindAddress, dockerAddress,
 	regexpDockerLabels, filter,
 	logLevel, additionalLabels,
 	hostNetworkingHost string
-	includeDockerLabels                                  bool
-	dockerRefreshInterval, discoverTimeout, proxyTimeout time.Duration
+	includeDockerLabels, discoverGzip                           bool
+	dockerRefreshInterval, discoverTimeout, proxyTimeout        time.Duration
+	drainPeriod, shutdownTimeout                                time.Duration
+	discoveryStaleFactor                                        float64
+	fileSDPath, fileSDFormat, fileSDScheme, fileSDTargetAddress string
+	fileSDSplitByProject                                        bool
+	shardKey                                                    string
+	metricsBearerToken, metricsBearerTokenFile                  string
+	// configViews are the named views of service discovery, read from `views` section of the config file.
+	configViews []*handler.ViewParams
+	// configTenants are the tenants allowed to access, read from `tenants` section of the config file.
+	configTenants []*handler.TenantParams
 )
 
+// addDiscovererFlags adds the flags to configure discovery to cmd.
+func addDiscovererFlags(cmd *cobra.Command) {
+	cmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "the severity for logging (error, info, warn, debug)")
+	cmd.Flags().StringVarP(&dockerAddress, "docker-address", "d", "unix:///var/run/docker.sock", "the address for Docker API")
+	cmd.Flags().IntVarP(&dockerPort, "docker-port", "", 8080, "the port for Docker API")
+	cmd.Flags().DurationVarP(&dockerRefreshInterval, "docker-refresh-interval", "", 30*time.Second, "the interval to poll Docker API")
+	cmd.Flags().DurationVarP(&discoverTimeout, "discover-timeout", "o", 30*time.Second, "timeout of discovery endpoint")
+	cmd.Flags().BoolVarP(&includeDockerLabels, "include-labels", "i", false, "whether the labels retrieved by docker API on discover endpoint response")
+	cmd.Flags().StringVarP(&regexpDockerLabels, "regexp-labels", "r", "", "regexp to filter Docker labels. must be used with --include-labels(-i) switch.")
+	cmd.Flags().StringVarP(&filter, "filter", "f", "", "filter output based on conditions provided. see https://docs.docker.com/reference/api/engine/version/v1.40/#tag/Container for the format.")
+	cmd.Flags().StringVarP(&additionalLabels, "additional-labels", "a", "", "labels to append on `labels` field of discover API response. must be key-value pair in JSON.")
+	cmd.Flags().StringVar(&hostNetworkingHost, "host-networking-host", "", "`HostNetworkingHost` value of Docker Service Discovery config")
+}
+
 func init() {
-	serverCmd.Flags().StringVarP(&logLevel, "log-level", "l", "info", "the severity for logging (error, info, warn, debug)")
-	serverCmd.Flags().StringVarP(&dockerAddress, "docker-address", "d", "unix:///var/run/docker.sock", "the address for Docker API")
-	serverCmd.Flags().IntVarP(&dockerPort, "docker-port", "", 8080, "the port for Docker API")
+	addDiscovererFlags(serverCmd)
 	serverCmd.Flags().StringVarP(&bindAddress, "bind-address", "b", "0.0.0.0", "the address listening on")
 	serverCmd.Flags().IntVarP(&port, "port", "p", 11298, "the port listening on")
-	serverCmd.Flags().DurationVarP(&dockerRefreshInterval, "docker-refresh-interval", "", 30*time.Second, "the interval to poll Docker API")
-	serverCmd.Flags().DurationVarP(&discoverTimeout, "discover-timeout", "o", 30*time.Second, "timeout of discovery endpoint")
 	serverCmd.Flags().DurationVarP(&proxyTimeout, "proxy-timeout", "t", 30*time.Second, "timeout of reverse-proxy endpoint")
-	serverCmd.Flags().BoolVarP(&includeDockerLabels, "include-labels", "i", false, "whether the labels retrieved by docker API on discover endpoint response")
-	serverCmd.Flags().StringVarP(&regexpDockerLabels, "regexp-labels", "r", "", "regexp to filter Docker labels. must be used with --include-labels(-i) switch.")
-	serverCmd.Flags().StringVarP(&filter, "filter", "f", "", "filter output based on conditions provided. see https://docs.docker.com/reference/api/engine/version/v1.40/#tag/Container for the format.")
-	serverCmd.Flags().StringVarP(&additionalLabels, "additional-labels", "a", "", "labels to append on `labels` field of discover API response. must be key-value pair in JSON.")
-	serverCmd.Flags().StringVar(&hostNetworkingHost, "host-networking-host", "", "`HostNetworkingHost` value of Docker Service Discovery config")
+	serverCmd.Flags().IntVar(&proxyMetricsMaxTargets, "proxy-metrics-max-targets", 500, "the maximum number of targets to have their own series of per-target proxy metrics. the rest share the series labeled \"_other\". negative disables the per-target metrics.")
+	serverCmd.Flags().BoolVar(&discoverGzip, "discover-gzip", false, "whether to compress the response of discover endpoint with gzip for the clients accepting it")
+	serverCmd.Flags().Float64Var(&discoveryStaleFactor, "discovery-stale-factor", 3, "the multiple of --docker-refresh-interval to regard the last discovery as stale and fail readiness. 0 disables it.")
+	serverCmd.Flags().DurationVar(&drainPeriod, "drain-period", 0, "the period to keep serving after turning not-ready on shutdown, to let clients notice it")
+	serverCmd.Flags().DurationVar(&shutdownTimeout, "shutdown-timeout", 30*time.Second, "the timeout to wait for in-flight requests and background tasks on shutdown")
+	serverCmd.Flags().StringVar(&shardKey, "shard-key", handler.ShardKeyHash, "the key to assign the targets to the shards requested by shard and shards query parameters of discover endpoint (hash, container, service)")
+	serverCmd.Flags().StringVar(&fileSDPath, "file-sd-path", "", "the path to write the targets in the format of file_sd whenever they change. disabled if empty.")
+	serverCmd.Flags().StringVar(&fileSDFormat, "file-sd-format", "", "the format of file_sd (json, yaml). guessed by the extension of --file-sd-path if empty.")
+	serverCmd.Flags().StringVar(&fileSDScheme, "file-sd-scheme", "http", "the scheme to reach prommux, written in each target of file_sd")
+	serverCmd.Flags().StringVar(&fileSDTargetAddress, "file-sd-target-address", "", "the address for Prometheus to reach prommux, written in each target of file_sd. e.g. prommux:11298. required with --file-sd-path.")
+	serverCmd.Flags().BoolVar(&fileSDSplitByProject, "file-sd-split-by-project", false, "whether to write the targets of each compose project into its own file beside --file-sd-path")
+	serverCmd.Flags().StringVar(&metricsBearerToken, "metrics-bearer-token", "", "the bearer token required for /metrics. /metrics is public if empty.")
+	serverCmd.Flags().StringVar(&metricsBearerTokenFile, "metrics-bearer-token-file", "", "the file to read the bearer token required for /metrics, if --metrics-bearer-token is empty")
+	bindFlagSources(serverCmd,
+		configSection{key: "views", target: &configViews},
+		configSection{key: "tenants", target: &configTenants},
+	)
 	rootCmd.AddCommand(serverCmd)
 }
@@ -0,0 +1,303 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/xruins/prommux/pkg/client"
+	"github.com/xruins/prommux/pkg/dockertest"
+)
+
+// newExporter starts an exporter serving body and returns its host and port.
+func newExporter(t *testing.T, body string) (string, uint16) {
+	t.Helper()
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, body)
+	}))
+	t.Cleanup(exporter.Close)
+	u, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	host, port, err := net.SplitHostPort(u.Host)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, err := strconv.ParseUint(port, 10, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return host, uint16(p)
+}
+
+// freePort returns a port which is free to listen on.
+func freePort(t *testing.T) int {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port
+}
+
+// waitFor polls f until it returns true.
+func waitFor(t *testing.T, what string, f func() bool) {
+	t.Helper()
+	timeout := time.After(10 * time.Second)
+	for !f() {
+		select {
+		case <-timeout:
+			t.Fatalf("timed out to wait for %s", what)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+}
+
+// TestServerEndToEnd runs serverCmd against the fake Docker API, and scrapes the exporters of the containers via the reverse proxy.
+func TestServerEndToEnd(t *testing.T) {
+	docker, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer docker.Close()
+	cleanupCommand(t, serverCmd)
+
+	bridgeHost, bridgePort := newExporter(t, "bridge 1\n")
+	hostHost, hostPort := newExporter(t, "host 1\n")
+	networkID := docker.AddNetwork(dockertest.Network{Name: "monitoring", Driver: "bridge", Scope: "local"})
+	docker.AddContainer(dockertest.Container{
+		Name:        "bridge-exporter",
+		Labels:      map[string]string{"prommux.enabled": "true"},
+		NetworkMode: "monitoring",
+		Networks:    map[string]*dockertest.Endpoint{"monitoring": {NetworkID: networkID, IPAddress: bridgeHost}},
+		Ports:       []dockertest.Port{{PrivatePort: bridgePort}},
+	})
+	hostID := docker.AddContainer(dockertest.Container{
+		Name:        "host-exporter",
+		Labels:      map[string]string{"prommux.enabled": "true"},
+		NetworkMode: "host",
+		Networks:    map[string]*dockertest.Endpoint{"host": {}},
+	})
+	docker.AddContainer(dockertest.Container{
+		Name:     "disabled-exporter",
+		Networks: map[string]*dockertest.Endpoint{"monitoring": {NetworkID: networkID, IPAddress: bridgeHost}},
+		Ports:    []dockertest.Port{{PrivatePort: bridgePort + 1}},
+	})
+
+	port := freePort(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	rootCmd.SetArgs([]string{
+		"server",
+		"--docker-address", docker.Host(),
+		"--docker-refresh-interval", "200ms",
+		"--filter", `{"label":["prommux.enabled=true"]}`,
+		"--host-networking-host", net.JoinHostPort(hostHost, strconv.Itoa(int(hostPort))),
+		"--bind-address", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--log-level", "error",
+	})
+	// cobra keeps the context of the first execution in the subcommands
+	serverCmd.SetContext(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	c, err := client.New(fmt.Sprintf("http://127.0.0.1:%d", port))
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "prommux to be ready", func() bool {
+		health, err := c.Ready(ctx)
+		return err == nil && health.Status == "ok"
+	})
+
+	configs, err := c.Discover(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(configs) != 2 {
+		t.Fatalf("unexpected number of targets. got: %d, want: 2", len(configs))
+	}
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bodies := make(map[string]bool)
+	for _, target := range status.Targets {
+		resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/proxy/%s", port, target.Hash))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("unexpected status code of %s: %d", target.URL, resp.StatusCode)
+		}
+		bodies[string(body)] = true
+	}
+	if !bodies["bridge 1\n"] || !bodies["host 1\n"] {
+		t.Errorf("the exporters of both containers must be scraped. got: %v", bodies)
+	}
+
+	// the removal of the container is discovered on the next refresh
+	docker.RemoveContainer(hostID)
+	waitFor(t, "the removed container to disappear", func() bool {
+		status, err := c.Status(ctx)
+		return err == nil && len(status.Targets) == 1
+	})
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Errorf("unexpected error on shutdown: %s", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Error("timed out to wait for the server to shut down")
+	}
+}
+
+// startDrainingServer runs serverCmd with drain period against the fake Docker API,
+// and starts its shutdown. It returns the URL of prommux and the channel of the result of the command
+// once prommux turns not-ready while draining.
+func startDrainingServer(t *testing.T, drain time.Duration) (string, <-chan error) {
+	t.Helper()
+	docker, err := dockertest.NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { docker.Close() })
+	cleanupCommand(t, serverCmd)
+
+	port := freePort(t)
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	rootCmd.SetArgs([]string{
+		"server",
+		"--docker-address", docker.Host(),
+		"--docker-refresh-interval", "200ms",
+		"--bind-address", "127.0.0.1",
+		"--port", strconv.Itoa(port),
+		"--drain-period", drain.String(),
+		"--log-level", "error",
+	})
+	// cobra keeps the context of the first execution in the subcommands
+	serverCmd.SetContext(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	u := fmt.Sprintf("http://127.0.0.1:%d", port)
+	c, err := client.New(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitFor(t, "prommux to be ready", func() bool {
+		health, err := c.Ready(t.Context())
+		return err == nil && health.Status == "ok"
+	})
+
+	cancel()
+	// the listener keeps serving while draining, and tells it is not ready.
+	waitFor(t, "prommux to turn not-ready", func() bool {
+		resp, err := http.Get(u + "/-/ready")
+		if err != nil {
+			t.Fatalf("prommux must keep serving while draining: %s", err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode == http.StatusServiceUnavailable
+	})
+	return u, errCh
+}
+
+// TestServerDrain checks that prommux shuts down in the order of
+// turning not-ready, draining, stopping the listener and stopping the background tasks.
+func TestServerDrain(t *testing.T) {
+	const drain = time.Second
+	start := time.Now()
+	u, errCh := startDrainingServer(t, drain)
+
+	resp, err := http.Get(u + "/-/healthy")
+	if err != nil {
+		t.Fatalf("prommux must keep serving while draining: %s", err)
+	}
+	resp.Body.Close()
+	select {
+	case err := <-errCh:
+		t.Fatalf("prommux must not exit while draining. err: %v", err)
+	default:
+	}
+
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out to wait for prommux to exit")
+	}
+	if elapsed := time.Since(start); elapsed < drain {
+		t.Errorf("prommux exited before the drain period elapsed. elapsed: %s", elapsed)
+	}
+	_, err = http.Get(u + "/-/ready")
+	if err == nil {
+		t.Error("the listener must be stopped after the drain period")
+	}
+}
+
+// TestServerDrainSecondSignal checks that the second signal cuts the drain period short.
+func TestServerDrainSecondSignal(t *testing.T) {
+	_, errCh := startDrainingServer(t, time.Minute)
+
+	// serverCmd handles the signal since it has turned not-ready.
+	err := syscall.Kill(syscall.Getpid(), syscall.SIGTERM)
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("the second signal must cut the drain period short")
+	}
+}
+
+func TestNewHandlerParamsFileSD(t *testing.T) {
+	t.Cleanup(func() { fileSDPath, fileSDTargetAddress = "", "" })
+	logger := slog.New(slog.DiscardHandler)
+
+	fileSDPath = filepath.Join(t.TempDir(), "prommux.json")
+	_, err := newHandlerParams(logger)
+	if err == nil {
+		t.Error("the target address must be required with the path of file_sd")
+	}
+
+	fileSDTargetAddress = "prommux:11298"
+	params, err := newHandlerParams(logger)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params.FileSD == nil || params.FileSD.Address != fileSDTargetAddress {
+		t.Errorf("unexpected params of file_sd: %+v", params.FileSD)
+	}
+}
@@ -0,0 +1,205 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/url"
+	"regexp"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/util/strutil"
+	"github.com/spf13/cobra"
+	"github.com/xruins/prommux/pkg/client"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+// targetsCmd prints the targets of a running prommux.
+var targetsCmd = &cobra.Command{
+	Use:   "targets",
+	Short: "Print the targets of a running prommux",
+	Long:  "Query the status API of a running prommux and print its targets",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		ctx := cmd.Context()
+		logger := slog.New(slog.NewJSONHandler(cmd.ErrOrStderr(), nil))
+
+		var u *url.URL
+		var err error
+		if targetsURL != "" {
+			u, err = url.Parse(targetsURL)
+			if err != nil {
+				return fmt.Errorf("failed to parse url option: %w", err)
+			}
+		} else {
+			u = &url.URL{
+				Scheme: "http",
+				Host:   fmt.Sprintf("%s:%d", targetsAddress, targetsPort),
+				Path:   "/status",
+			}
+		}
+
+		filter, err := newTargetsFilter(targetsLabels, targetsName)
+		if err != nil {
+			return err
+		}
+
+		// the client requests the status API under the base URL
+		u.Path = strings.TrimSuffix(u.Path, "/status")
+		c, err := client.New(u.String(), client.WithHTTPConfig(&targetsHTTPConfig))
+		if err != nil {
+			return fmt.Errorf("failed to create client: %w", err)
+		}
+		out := cmd.OutOrStdout()
+		for {
+			status, err := c.Status(ctx)
+			switch {
+			case err != nil && !targetsWatch:
+				return err
+			case err != nil:
+				// the server may be restarting while watching
+				logger.ErrorContext(ctx, "failed to request status API", slog.Any("error", err))
+			default:
+				targets := filter.apply(status.Targets)
+				if targetsWatch && targetsOutput == outputFormatTable {
+					// clear the screen
+					fmt.Fprint(out, "\033[H\033[2J")
+				}
+				err = writeTargets(out, targets, targetsOutput)
+				if err != nil {
+					return err
+				}
+				if !targetsWatch {
+					return nil
+				}
+			}
+
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(targetsWatchInterval):
+			}
+		}
+	},
+}
+
+// targetsFilter filters targets by the labels and the names of their containers.
+type targetsFilter struct {
+	labels model.LabelSet
+	name   *regexp.Regexp
+}
+
+func newTargetsFilter(labels []string, name string) (*targetsFilter, error) {
+	f := &targetsFilter{}
+	for _, l := range labels {
+		k, v, ok := strings.Cut(l, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid label filter `%s`. must be in the format of `key=value`", l)
+		}
+		if f.labels == nil {
+			f.labels = model.LabelSet{}
+		}
+		f.labels[model.LabelName(k)] = model.LabelValue(v)
+	}
+	if name != "" {
+		var err error
+		f.name, err = regexp.Compile(name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile name filter: %w", err)
+		}
+	}
+	return f, nil
+}
+
+// matchContainer reports whether the container satisfies all the conditions.
+// The keys of label conditions are compared with both the labels of Prometheus and Docker.
+// e.g. `com.docker.compose.service` matches `__meta_docker_container_label_com_docker_compose_service`.
+func (f *targetsFilter) matchContainer(c *handler.ResponseStatusContainer) bool {
+	if f.name != nil && !f.name.MatchString(strings.TrimPrefix(c.Name, "/")) {
+		return false
+	}
+	for k, v := range f.labels {
+		got, ok := c.Labels[k]
+		if !ok {
+			got, ok = c.Labels[model.LabelName("__meta_docker_container_label_"+strutil.SanitizeLabelName(string(k)))]
+		}
+		if !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+func (f *targetsFilter) apply(targets []*handler.ResponseStatusTarget) []*handler.ResponseStatusTarget {
+	if f.name == nil && len(f.labels) == 0 {
+		return targets
+	}
+	ret := make([]*handler.ResponseStatusTarget, 0, len(targets))
+	for _, t := range targets {
+		for _, c := range t.Containers {
+			if f.matchContainer(c) {
+				ret = append(ret, t)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// writeTargets writes targets to w in the given format.
+func writeTargets(w io.Writer, targets []*handler.ResponseStatusTarget, format string) error {
+	switch format {
+	case outputFormatJSON:
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(targets)
+	case outputFormatTable:
+		tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+		fmt.Fprintln(tw, "HASH\tUPSTREAM URL\tCONTAINERS\tLAST SCRAPE")
+		for _, t := range targets {
+			names := make([]string, 0, len(t.Containers))
+			for _, c := range t.Containers {
+				names = append(names, strings.TrimPrefix(c.Name, "/"))
+			}
+			lastScrape := "-"
+			if t.LastScrape != nil {
+				lastScrape = fmt.Sprintf(
+					"%d (%s ago)",
+					t.LastScrape.StatusCode,
+					time.Since(t.LastScrape.Time).Truncate(time.Second),
+				)
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", t.Hash, t.URL, strings.Join(names, ","), lastScrape)
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("unknown output format `%s`. (candidates: table, json)", format)
+	}
+}
+
+var (
+	targetsURL, targetsAddress, targetsOutput, targetsName string
+	targetsPort                                            int
+	targetsWatchInterval                                   time.Duration
+	targetsHTTPConfig                                      client.HTTPConfig
+	targetsWatch                                           bool
+	targetsLabels                                          []string
+)
+
+func init() {
+	targetsCmd.Flags().StringVarP(&targetsURL, "url", "u", "", "the url of status API. if specified, `-a` and `-p` options will be ignored.")
+	targetsCmd.Flags().StringVarP(&targetsAddress, "address", "a", "127.0.0.1", "the address of prommux")
+	targetsCmd.Flags().IntVarP(&targetsPort, "port", "p", 11298, "the port of prommux")
+	targetsCmd.Flags().DurationVarP(&targetsHTTPConfig.Timeout, "timeout", "t", 30*time.Second, "the timeout to request status API")
+	addHTTPClientFlags(targetsCmd, &targetsHTTPConfig)
+	targetsCmd.Flags().StringVarP(&targetsOutput, "output", "o", outputFormatTable, "the output format (table, json)")
+	targetsCmd.Flags().BoolVarP(&targetsWatch, "watch", "w", false, "whether to keep printing the targets periodically. the failures to request are logged without exiting.")
+	targetsCmd.Flags().DurationVar(&targetsWatchInterval, "watch-interval", 2*time.Second, "the interval to print the targets with --watch(-w)")
+	targetsCmd.Flags().StringArrayVarP(&targetsLabels, "label", "L", nil, "print only the targets whose container has the label. in the format of `key=value`. can be specified multiple times.")
+	targetsCmd.Flags().StringVarP(&targetsName, "name", "n", "", "regexp to filter the targets by container name")
+	bindFlagSources(targetsCmd)
+	rootCmd.AddCommand(targetsCmd)
+}
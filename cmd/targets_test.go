@@ -0,0 +1,187 @@
+package cmd
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+func newTestStatusTargets() []*handler.ResponseStatusTarget {
+	return []*handler.ResponseStatusTarget{
+		{
+			URL:  "http://172.18.0.2:9100/metrics",
+			Hash: "abc",
+			Containers: []*handler.ResponseStatusContainer{
+				{
+					ID:     "0123",
+					Name:   "/shop-web-1",
+					Labels: model.LabelSet{"__meta_docker_container_label_com_docker_compose_service": "web"},
+				},
+				{ID: "4567", Name: "/shop-web-2"},
+			},
+			LastScrape: &handler.ScrapeResult{
+				Time:       time.Now().Add(-90 * time.Second),
+				StatusCode: 200,
+			},
+		},
+		{
+			URL:  "http://172.18.0.3:8080/metrics",
+			Hash: "def",
+			Containers: []*handler.ResponseStatusContainer{
+				{ID: "89ab", Name: "/shop-db-1"},
+			},
+		},
+	}
+}
+
+func TestWriteTargets(t *testing.T) {
+	targets := newTestStatusTargets()
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeTargets(&buf, targets, outputFormatTable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := "" +
+			"HASH  UPSTREAM URL                    CONTAINERS             LAST SCRAPE\n" +
+			"abc   http://172.18.0.2:9100/metrics  shop-web-1,shop-web-2  200 (1m30s ago)\n" +
+			"def   http://172.18.0.3:8080/metrics  shop-db-1              -\n"
+		if diff := cmp.Diff(want, buf.String()); diff != "" {
+			t.Errorf("unexpected output (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		err := writeTargets(&buf, targets, outputFormatJSON)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []*handler.ResponseStatusTarget
+		err = json.Unmarshal(buf.Bytes(), &got)
+		if err != nil {
+			t.Fatalf("output is not JSON: %s", err)
+		}
+		if len(got) != 2 || got[0].Hash != "abc" || got[1].Hash != "def" || got[0].LastScrape.StatusCode != 200 {
+			t.Errorf("unexpected output: %s", buf.String())
+		}
+	})
+
+	t.Run("unknown", func(t *testing.T) {
+		err := writeTargets(&bytes.Buffer{}, targets, outputFormatYAML)
+		if err == nil {
+			t.Error("error must be returned for unsupported format")
+		}
+	})
+}
+
+func TestTargetsFilter(t *testing.T) {
+	for name, tc := range map[string]struct {
+		labels []string
+		name   string
+		want   []string
+	}{
+		"no filter":           {want: []string{"abc", "def"}},
+		"docker label":        {labels: []string{"com.docker.compose.service=web"}, want: []string{"abc"}},
+		"name":                {name: "^shop-db", want: []string{"def"}},
+		"name of any":         {name: "web-2$", want: []string{"abc"}},
+		"label and name":      {labels: []string{"com.docker.compose.service=web"}, name: "db", want: []string{}},
+		"unmatched label key": {labels: []string{"job=web"}, want: []string{}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			f, err := newTargetsFilter(tc.labels, tc.name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := []string{}
+			for _, target := range f.apply(newTestStatusTargets()) {
+				got = append(got, target.Hash)
+			}
+			if diff := cmp.Diff(tc.want, got); diff != "" {
+				t.Errorf("unexpected targets (-want +got):\n%s", diff)
+			}
+		})
+	}
+
+	_, err := newTargetsFilter([]string{"job"}, "")
+	if err == nil {
+		t.Error("error must be returned for label filter without value")
+	}
+}
+
+func TestTargetsWatch(t *testing.T) {
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		// the first request fails as if the server is restarting
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(&handler.ResponseStatus{Targets: newTestStatusTargets()})
+	}))
+	t.Cleanup(server.Close)
+
+	var stdout, stderr syncBuffer
+	rootCmd.SetOut(&stdout)
+	rootCmd.SetErr(&stderr)
+	t.Cleanup(func() {
+		rootCmd.SetOut(nil)
+		rootCmd.SetErr(nil)
+	})
+	cleanupCommand(t, targetsCmd)
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+	rootCmd.SetArgs([]string{"targets", "--url", server.URL + "/status", "--bearer-token", "secret", "--output", "json", "--watch", "--watch-interval", "10ms"})
+	// cobra keeps the context of the first execution in the subcommands
+	targetsCmd.SetContext(ctx)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- rootCmd.ExecuteContext(ctx)
+	}()
+
+	waitFor(t, "the targets to be printed", func() bool {
+		return strings.Contains(stdout.String(), `"hash": "def"`)
+	})
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatalf("--watch must not exit on failures to request: %s", err)
+	}
+	if !strings.Contains(stderr.String(), "failed to request status API") {
+		t.Errorf("the failure to request must be logged. got: %s", stderr.String())
+	}
+}
+
+// syncBuffer is bytes.Buffer safe for the command writing in another goroutine.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
@@ -0,0 +1,260 @@
+// Package client is the client of the HTTP API of prommux.
+//
+// The responses are decoded into the types of package handler, which the server encodes,
+// so that the client does not drift from the server.
+package client
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+// maxErrorBodySize is the maximum size of the body read into StatusError.
+const maxErrorBodySize = 4096
+
+// Client requests the HTTP API of prommux.
+type Client struct {
+	baseURL    *url.URL
+	httpClient *http.Client
+}
+
+// Option configures Client created by New.
+type Option func(*options)
+
+type options struct {
+	httpClient *http.Client
+	httpConfig *HTTPConfig
+}
+
+// WithHTTPClient makes Client send the requests by client.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *options) {
+		o.httpClient = client
+	}
+}
+
+// WithHTTPConfig makes Client send the requests with TLS and authentication configured by config.
+// It is ignored if WithHTTPClient is given.
+func WithHTTPConfig(config *HTTPConfig) Option {
+	return func(o *options) {
+		o.httpConfig = config
+	}
+}
+
+// New creates Client for prommux serving on baseURL. e.g. `http://127.0.0.1:11298`
+// The path of baseURL is prepended to the paths of the endpoints, if prommux is served under a prefix.
+func New(baseURL string, opts ...Option) (*Client, error) {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse base URL: %w", err)
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, fmt.Errorf("base URL `%s` must have scheme and host", baseURL)
+	}
+
+	o := &options{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	httpClient := o.httpClient
+	if httpClient == nil {
+		config := o.httpConfig
+		if config == nil {
+			config = &HTTPConfig{}
+		}
+		httpClient, err = config.NewHTTPClient()
+		if err != nil {
+			return nil, fmt.Errorf("failed to create HTTP client: %w", err)
+		}
+	}
+	return &Client{baseURL: u, httpClient: httpClient}, nil
+}
+
+// ErrNotFound is returned if the requested resource does not exist.
+var ErrNotFound = errors.New("not found")
+
+// StatusError is returned if an endpoint responds with an unexpected status code.
+type StatusError struct {
+	Path       string
+	StatusCode int
+	// Body is the head of the response body.
+	Body string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s returned status code %d: %s", e.Path, e.StatusCode, e.Body)
+}
+
+// get requests the endpoint at path, and decodes the JSON response into v.
+// The response is decoded for the status codes in accepted as well as 200 OK.
+func (c *Client) get(ctx context.Context, path string, query url.Values, v any, accepted ...int) error {
+	u := c.baseURL.JoinPath(path)
+	u.RawQuery = query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to request %s: %w", path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && !slices.Contains(accepted, resp.StatusCode) {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, maxErrorBodySize))
+		return &StatusError{
+			Path:       path,
+			StatusCode: resp.StatusCode,
+			Body:       strings.TrimSpace(string(body)),
+		}
+	}
+	err = json.NewDecoder(resp.Body).Decode(v)
+	if err != nil {
+		return fmt.Errorf("failed to decode the response of %s: %w", path, err)
+	}
+	return nil
+}
+
+// Discover returns the targets served by HTTP service discovery.
+func (c *Client) Discover(ctx context.Context) ([]*handler.StaticConfig, error) {
+	var configs []*handler.StaticConfig
+	err := c.get(ctx, "/discover", nil, &configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// DiscoverShard returns the targets assigned to the shard out of shards by HTTP service discovery.
+func (c *Client) DiscoverShard(ctx context.Context, shard, shards int) ([]*handler.StaticConfig, error) {
+	query := url.Values{
+		"shard":  {strconv.Itoa(shard)},
+		"shards": {strconv.Itoa(shards)},
+	}
+	var configs []*handler.StaticConfig
+	err := c.get(ctx, "/discover", query, &configs)
+	if err != nil {
+		return nil, err
+	}
+	return configs, nil
+}
+
+// Status returns the targets and the configuration.
+func (c *Client) Status(ctx context.Context) (*handler.ResponseStatus, error) {
+	status := &handler.ResponseStatus{}
+	err := c.get(ctx, "/status", nil, status)
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+// Healthy returns the state of the components for liveness.
+// The response is returned without error even if it is not healthy. Check Status of the response.
+func (c *Client) Healthy(ctx context.Context) (*handler.ResponseHealth, error) {
+	return c.health(ctx, "/-/healthy")
+}
+
+// Ready returns the state of the components for readiness.
+// The response is returned without error even if it is not ready. Check Status of the response.
+func (c *Client) Ready(ctx context.Context) (*handler.ResponseHealth, error) {
+	return c.health(ctx, "/-/ready")
+}
+
+func (c *Client) health(ctx context.Context, path string) (*handler.ResponseHealth, error) {
+	health := &handler.ResponseHealth{}
+	err := c.get(ctx, path, nil, health, http.StatusServiceUnavailable)
+	if err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// Explain returns how the targets of the container are generated.
+// id is either the ID, the prefix of the ID or the name of the container.
+// It returns ErrNotFound if no target is found for the container.
+func (c *Client) Explain(ctx context.Context, id string) ([]*handler.Explanation, error) {
+	var explanations []*handler.Explanation
+	err := c.get(ctx, "/debug/explain/"+id, nil, &explanations)
+	var statusErr *StatusError
+	if errors.As(err, &statusErr) && statusErr.StatusCode == http.StatusNotFound {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, statusErr.Body)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return explanations, nil
+}
+
+// TargetsQuery is the query to filter and paginate the targets of Targets.
+// The zero value matches all the targets in the first page.
+type TargetsQuery struct {
+	Hash      string
+	Container string
+	// Status is either of `ok`, `error`, `unknown` or `quarantined`.
+	Status string
+	Labels model.LabelSet
+	// Limit is the number of targets in a page. The server default is used if 0.
+	Limit  int
+	Offset int
+}
+
+func (q *TargetsQuery) values() url.Values {
+	values := url.Values{}
+	if q == nil {
+		return values
+	}
+	if q.Hash != "" {
+		values.Set("hash", q.Hash)
+	}
+	if q.Container != "" {
+		values.Set("container", q.Container)
+	}
+	if q.Status != "" {
+		values.Set("status", q.Status)
+	}
+	for name, value := range q.Labels {
+		values.Add("label", string(name)+"="+string(value))
+	}
+	if q.Limit > 0 {
+		values.Set("limit", strconv.Itoa(q.Limit))
+	}
+	if q.Offset > 0 {
+		values.Set("offset", strconv.Itoa(q.Offset))
+	}
+	return values
+}
+
+// Targets returns a page of the targets with their runtime data, filtered by q.
+func (c *Client) Targets(ctx context.Context, q *TargetsQuery) (*handler.ResponseTargets, error) {
+	targets := &handler.ResponseTargets{}
+	err := c.get(ctx, "/api/v1/targets", q.values(), targets)
+	if err != nil {
+		return nil, err
+	}
+	return targets, nil
+}
+
+// Config returns the configuration of prommux, with the secrets redacted.
+func (c *Client) Config(ctx context.Context) (*handler.HandlerParams, error) {
+	config := &handler.HandlerParams{}
+	err := c.get(ctx, "/api/v1/config", nil, config)
+	if err != nil {
+		return nil, err
+	}
+	return config, nil
+}
@@ -0,0 +1,104 @@
+package client
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// userAgent is the User-Agent of the requests to prommux.
+const userAgent = "Prommux"
+
+// HTTPConfig is the configuration of TLS and authentication to request prommux.
+type HTTPConfig struct {
+	Timeout time.Duration
+	// CAFile is the CA certificate file to verify the server.
+	CAFile string
+	// CertFile and KeyFile are the client certificate and key for TLS authentication.
+	CertFile, KeyFile  string
+	ServerName         string
+	InsecureSkipVerify bool
+	// Username and Password are for basic authentication.
+	Username, Password string
+	// BearerToken and BearerTokenFile are mutually exclusive, and exclusive to basic authentication.
+	BearerToken, BearerTokenFile string
+}
+
+// authRoundTripper sets the headers for authentication to each request.
+type authRoundTripper struct {
+	next                          http.RoundTripper
+	username, password, bearerKey string
+}
+
+func (rt *authRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", userAgent)
+	if rt.username != "" || rt.password != "" {
+		req.SetBasicAuth(rt.username, rt.password)
+	}
+	if rt.bearerKey != "" {
+		req.Header.Set("Authorization", "Bearer "+rt.bearerKey)
+	}
+	return rt.next.RoundTrip(req)
+}
+
+// NewHTTPClient creates *http.Client with TLS and authentication configured by c.
+func (c *HTTPConfig) NewHTTPClient() (*http.Client, error) {
+	tlsConfig := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		data, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no certificate found in CA file `%s`", c.CAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		if c.CertFile == "" || c.KeyFile == "" {
+			return nil, errors.New("both of client certificate and key must be specified")
+		}
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	bearerToken := c.BearerToken
+	if c.BearerTokenFile != "" {
+		if bearerToken != "" {
+			return nil, errors.New("bearer token and bearer token file are mutually exclusive")
+		}
+		data, err := os.ReadFile(c.BearerTokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read bearer token file: %w", err)
+		}
+		bearerToken = strings.TrimSpace(string(data))
+	}
+	if bearerToken != "" && (c.Username != "" || c.Password != "") {
+		return nil, errors.New("basic auth and bearer token are mutually exclusive")
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+	return &http.Client{
+		Timeout: c.Timeout,
+		Transport: &authRoundTripper{
+			next:      transport,
+			username:  c.Username,
+			password:  c.Password,
+			bearerKey: bearerToken,
+		},
+	}, nil
+}
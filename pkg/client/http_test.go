@@ -0,0 +1,67 @@
+package client
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(content), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNewHTTPClientErrors(t *testing.T) {
+	tokenFile := writeTestFile(t, "token", "secret\n")
+	notPEM := writeTestFile(t, "not.pem", "not a certificate")
+	missing := filepath.Join(t.TempDir(), "missing")
+
+	for name, tc := range map[string]struct {
+		config  HTTPConfig
+		wantErr string
+	}{
+		"empty":                        {},
+		"basic auth":                   {config: HTTPConfig{Username: "user", Password: "pass"}},
+		"bearer token":                 {config: HTTPConfig{BearerToken: "secret"}},
+		"bearer token file":            {config: HTTPConfig{BearerTokenFile: tokenFile}},
+		"insecure":                     {config: HTTPConfig{InsecureSkipVerify: true, ServerName: "prommux"}},
+		"token and token file":         {config: HTTPConfig{BearerToken: "secret", BearerTokenFile: tokenFile}, wantErr: "mutually exclusive"},
+		"token and basic auth":         {config: HTTPConfig{BearerToken: "secret", Username: "user"}, wantErr: "mutually exclusive"},
+		"token and password":           {config: HTTPConfig{BearerToken: "secret", Password: "pass"}, wantErr: "mutually exclusive"},
+		"token file and basic":         {config: HTTPConfig{BearerTokenFile: tokenFile, Username: "user"}, wantErr: "mutually exclusive"},
+		"missing token file":           {config: HTTPConfig{BearerTokenFile: missing}, wantErr: "failed to read bearer token file"},
+		"missing CA file":              {config: HTTPConfig{CAFile: missing}, wantErr: "failed to read CA file"},
+		"CA file without certs":        {config: HTTPConfig{CAFile: notPEM}, wantErr: "no certificate found"},
+		"cert without key":             {config: HTTPConfig{CertFile: notPEM}, wantErr: "both of client certificate and key"},
+		"key without cert":             {config: HTTPConfig{KeyFile: notPEM}, wantErr: "both of client certificate and key"},
+		"invalid cert and key":         {config: HTTPConfig{CertFile: notPEM, KeyFile: notPEM}, wantErr: "failed to load client certificate"},
+		"missing cert and key":         {config: HTTPConfig{CertFile: missing, KeyFile: missing}, wantErr: "failed to load client certificate"},
+		"CA file before auth":          {config: HTTPConfig{CAFile: notPEM, BearerToken: "secret"}, wantErr: "no certificate found"},
+		"token and missing token file": {config: HTTPConfig{BearerToken: "secret", BearerTokenFile: missing}, wantErr: "mutually exclusive"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			c, err := tc.config.NewHTTPClient()
+			if tc.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				if c == nil {
+					t.Error("client must be returned")
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("error containing %q must be returned", tc.wantErr)
+			}
+			if !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("unexpected error. want: %q, got: %q", tc.wantErr, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package compose
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Project is a docker-compose file.
+type Project struct {
+	Services []*Service
+}
+
+// Service is a service defined in a docker-compose file.
+type Service struct {
+	Name   string
+	Line   int
+	Labels []*Label
+	Ports  []*Port
+}
+
+// Label is a label of a service with its position in the file.
+type Label struct {
+	Key, Value   string
+	Line, Column int
+}
+
+// Port is a port of container declared by `ports` or `expose` of a service.
+type Port struct {
+	// Container is the port number in the container.
+	Container int
+	Line      int
+}
+
+// Parse parses the content of a docker-compose file.
+func Parse(data []byte) (*Project, error) {
+	var doc yaml.Node
+	err := yaml.Unmarshal(data, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse YAML: %w", err)
+	}
+	if len(doc.Content) == 0 {
+		return &Project{}, nil
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: the top level must be a mapping", root.Line)
+	}
+
+	project := &Project{}
+	services := mappingValue(root, "services")
+	if services == nil {
+		return project, nil
+	}
+	if services.Kind != yaml.MappingNode {
+		return nil, fmt.Errorf("line %d: `services` must be a mapping", services.Line)
+	}
+	for i := 0; i+1 < len(services.Content); i += 2 {
+		key, value := services.Content[i], services.Content[i+1]
+		svc := &Service{Name: key.Value, Line: key.Line}
+		if value.Kind == yaml.MappingNode {
+			svc.Labels, err = parseLabels(mappingValue(value, "labels"))
+			if err != nil {
+				return nil, fmt.Errorf("service `%s`: %w", svc.Name, err)
+			}
+			svc.Ports, err = parsePorts(mappingValue(value, "ports"), mappingValue(value, "expose"))
+			if err != nil {
+				return nil, fmt.Errorf("service `%s`: %w", svc.Name, err)
+			}
+		}
+		project.Services = append(project.Services, svc)
+	}
+	return project, nil
+}
+
+// mappingValue returns the value for key in the mapping node, or nil if missing.
+func mappingValue(n *yaml.Node, key string) *yaml.Node {
+	if n == nil || n.Kind != yaml.MappingNode {
+		return nil
+	}
+	for i := 0; i+1 < len(n.Content); i += 2 {
+		if n.Content[i].Value == key {
+			return n.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// parseLabels parses `labels` in either mapping or list syntax.
+func parseLabels(n *yaml.Node) ([]*Label, error) {
+	if n == nil {
+		return nil, nil
+	}
+	var ret []*Label
+	switch n.Kind {
+	case yaml.MappingNode:
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key, value := n.Content[i], n.Content[i+1]
+			ret = append(ret, &Label{Key: key.Value, Value: value.Value, Line: key.Line, Column: key.Column})
+		}
+	case yaml.SequenceNode:
+		for _, e := range n.Content {
+			k, v, _ := strings.Cut(e.Value, "=")
+			ret = append(ret, &Label{Key: k, Value: v, Line: e.Line, Column: e.Column})
+		}
+	default:
+		return nil, fmt.Errorf("line %d: `labels` must be a mapping or a list", n.Line)
+	}
+	return ret, nil
+}
+
+// parsePorts parses `ports` and `expose` of a service.
+func parsePorts(ports, expose *yaml.Node) ([]*Port, error) {
+	var ret []*Port
+	for _, n := range []*yaml.Node{ports, expose} {
+		if n == nil {
+			continue
+		}
+		if n.Kind != yaml.SequenceNode {
+			return nil, fmt.Errorf("line %d: ports must be a list", n.Line)
+		}
+		for _, e := range n.Content {
+			var spec string
+			switch e.Kind {
+			case yaml.ScalarNode:
+				spec = e.Value
+			case yaml.MappingNode:
+				// long syntax
+				target := mappingValue(e, "target")
+				if target == nil {
+					return nil, fmt.Errorf("line %d: `target` is missing in the port", e.Line)
+				}
+				if protocol := mappingValue(e, "protocol"); protocol != nil && protocol.Value != "tcp" {
+					continue
+				}
+				spec = target.Value
+			default:
+				return nil, fmt.Errorf("line %d: invalid port", e.Line)
+			}
+			containerPorts, err := parseContainerPorts(spec)
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", e.Line, err)
+			}
+			for _, p := range containerPorts {
+				ret = append(ret, &Port{Container: p, Line: e.Line})
+			}
+		}
+	}
+	return ret, nil
+}
+
+// parseContainerPorts returns the TCP ports in the container from the short syntax of ports.
+// e.g. `9100`, `8080:9100`, `127.0.0.1:8080:9100/tcp` and `9100-9101`.
+func parseContainerPorts(spec string) ([]int, error) {
+	spec, protocol, _ := strings.Cut(spec, "/")
+	if protocol != "" && protocol != "tcp" {
+		return nil, nil
+	}
+	// the container port is the last part separated by colons
+	container := spec
+	if i := strings.LastIndex(spec, ":"); i >= 0 {
+		container = spec[i+1:]
+	}
+
+	first, last, isRange := strings.Cut(container, "-")
+	from, err := strconv.Atoi(first)
+	if err != nil {
+		return nil, fmt.Errorf("invalid port `%s`", spec)
+	}
+	to := from
+	if isRange {
+		to, err = strconv.Atoi(last)
+		if err != nil || to < from {
+			return nil, fmt.Errorf("invalid port range `%s`", spec)
+		}
+	}
+	ret := make([]int, 0, to-from+1)
+	for p := from; p <= to; p++ {
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
@@ -0,0 +1,59 @@
+package compose
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestParse(t *testing.T) {
+	data := []byte(`services:
+  web:
+    image: nginx
+    ports:
+      - "127.0.0.1:8080:80/tcp"
+      - "53:53/udp"
+      - target: 9113
+        published: 9113
+    expose:
+      - "9000-9001"
+    labels:
+      prommux.address: "{{ .OriginalHost }}:9113"
+  db:
+    image: postgres
+    labels:
+      - "prommux.metrics_path=/pg"
+`)
+
+	got, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Project{
+		Services: []*Service{
+			{
+				Name: "web",
+				Line: 2,
+				Labels: []*Label{
+					{Key: "prommux.address", Value: "{{ .OriginalHost }}:9113", Line: 12, Column: 7},
+				},
+				Ports: []*Port{
+					{Container: 80, Line: 5},
+					{Container: 9113, Line: 7},
+					{Container: 9000, Line: 10},
+					{Container: 9001, Line: 10},
+				},
+			},
+			{
+				Name: "db",
+				Line: 13,
+				Labels: []*Label{
+					{Key: "prommux.metrics_path", Value: "/pg", Line: 16, Column: 9},
+				},
+			},
+		},
+	}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("unexpected project. diff(-got, +want): %s", diff)
+	}
+}
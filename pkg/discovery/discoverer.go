@@ -3,6 +3,7 @@ package discovery
 import (
 	"fmt"
 	"log/slog"
+	"net/url"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
@@ -14,6 +15,9 @@ import (
 	"context"
 )
 
+// instanceLabel is the label to distinguish the metrics of discoverers.
+const instanceLabel = "discoverer"
+
 // Discoverer is to get information of Docker containers from Docker API.
 type Discoverer struct {
 	logger             *slog.Logger
@@ -22,10 +26,12 @@ type Discoverer struct {
 	filter             []moby.Filter
 	interval           time.Duration
 	hostNetworkingHost string
-	ch                 chan<- []*targetgroup.Group
+	// refreshMetrics are the metrics of Docker SD, kept registered across runs.
+	refreshMetrics discovery.RefreshMetricsManager
 }
 
 // NewDiscover instantinates discoverer and returns it.
+// The metrics of Docker SD are registered on reg, labeled with the name of the discoverer.
 func NewDiscoverer(
 	logger *slog.Logger,
 	host string,
@@ -33,8 +39,16 @@ func NewDiscoverer(
 	filter []moby.Filter,
 	interval time.Duration,
 	hostNetworkingHost string,
-	ch chan<- []*targetgroup.Group,
-) *Discoverer {
+	reg prometheus.Registerer,
+	name string,
+) (*Discoverer, error) {
+	refreshMetrics := discovery.NewRefreshMetrics(
+		prometheus.WrapRegistererWith(prometheus.Labels{instanceLabel: name}, reg),
+	)
+	err := refreshMetrics.Register()
+	if err != nil {
+		return nil, fmt.Errorf("could not register refresh metrics: %w", err)
+	}
 	return &Discoverer{
 		logger:             logger,
 		host:               host,
@@ -42,14 +56,29 @@ func NewDiscoverer(
 		filter:             filter,
 		interval:           interval,
 		hostNetworkingHost: hostNetworkingHost,
-		ch:                 ch,
+		refreshMetrics:     refreshMetrics,
+	}, nil
+}
+
+// Unregister unregisters the metrics of the discoverer.
+// It must be called after the last run, if the discoverer is no longer used.
+func (d *Discoverer) Unregister() {
+	d.refreshMetrics.Unregister()
+}
+
+// String returns the name of the discoverer.
+// The credentials in the address of Docker API are redacted, since the name is logged.
+func (d *Discoverer) String() string {
+	host := d.host
+	if u, err := url.Parse(host); err == nil && u.User != nil {
+		host = u.Redacted()
 	}
+	return "docker discoverer for " + host
 }
 
-// Run runs initialize docker discoverer and let it run.
-func (d *Discoverer) Run(ctx context.Context) error {
-	reg := prometheus.NewRegistry()
-	refreshMetrics := discovery.NewRefreshMetrics(reg)
+// Run runs initialize docker discoverer and let it run, sending the target groups to ch.
+// It can be called again after it returns, to restart the discoverer.
+func (d *Discoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) error {
 	cfg := moby.DefaultDockerSDConfig
 	cfg.Host = d.host
 	cfg.Port = d.port
@@ -58,21 +87,14 @@ func (d *Discoverer) Run(ctx context.Context) error {
 	if d.hostNetworkingHost != "" {
 		cfg.HostNetworkingHost = d.hostNetworkingHost
 	}
-	metrics := cfg.NewDiscovererMetrics(reg, refreshMetrics)
-	err := metrics.Register()
-	if err != nil {
-		return fmt.Errorf("could not register service discovery metrics: %w", err)
-	}
+	// the metrics of Docker SD itself are empty, and are not registered
+	metrics := cfg.NewDiscovererMetrics(nil, d.refreshMetrics)
 
 	discoverer, err := cfg.NewDiscoverer(discovery.DiscovererOptions{Logger: d.logger, Metrics: metrics})
 	if err != nil {
 		return fmt.Errorf("could not create Discoverer: %w", err)
 	}
 
-	defer func() {
-		metrics.Unregister()
-		refreshMetrics.Unregister()
-	}()
-	discoverer.Run(ctx, d.ch)
+	discoverer.Run(ctx, ch)
 	return nil
 }
@@ -0,0 +1,450 @@
+// Package dockertest provides an in-process fake of Docker Engine API for integration tests.
+//
+// Server listens on a Unix socket and serves `/_ping`, `/version`, `/containers/json`, `/networks` and `/events`
+// from the set of containers and networks programmed by the test, so that the real Docker discoverer can run against it.
+package dockertest
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/docker/docker/api/types"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/network"
+)
+
+// APIVersion is the version of Docker Engine API served by Server.
+const APIVersion = "1.47"
+
+// the states of containers.
+const (
+	StateRunning = "running"
+	StateExited  = "exited"
+)
+
+// Container is a container listed by Server.
+type Container struct {
+	// ID is generated if empty.
+	ID string
+	// Name is the name without the leading slash.
+	Name   string
+	Image  string
+	Labels map[string]string
+	// State is StateRunning if empty.
+	State string
+	// NetworkMode is the network mode of the container. e.g. `bridge`, `host` or `container:<id>`
+	NetworkMode string
+	// Networks are the endpoints of the container keyed by the name of the network.
+	Networks map[string]*Endpoint
+	Ports    []Port
+}
+
+// Endpoint is the endpoint of a container in a network.
+type Endpoint struct {
+	NetworkID string
+	IPAddress string
+}
+
+// Port is a port exposed by a container.
+type Port struct {
+	PrivatePort uint16
+	PublicPort  uint16
+	IP          string
+	// Type is `tcp` if empty.
+	Type string
+}
+
+// Network is a network listed by Server.
+type Network struct {
+	// ID is generated if empty.
+	ID       string
+	Name     string
+	Driver   string
+	Scope    string
+	Internal bool
+	Labels   map[string]string
+}
+
+// Server is a fake of Docker Engine API.
+type Server struct {
+	dir      string
+	listener net.Listener
+	server   *http.Server
+
+	mu         sync.Mutex
+	containers []*Container
+	networks   []*Network
+	// failure is the status code to respond to the list endpoints with, if not 0.
+	failure     int
+	requests    map[string]int
+	subscribers map[chan events.Message]struct{}
+	seq         int
+}
+
+// NewServer starts Server listening on a Unix socket in a temporary directory.
+// Close must be called to stop it and remove the directory.
+func NewServer() (*Server, error) {
+	dir, err := os.MkdirTemp("", "dockertest")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	listener, err := net.Listen("unix", filepath.Join(dir, "docker.sock"))
+	if err != nil {
+		os.RemoveAll(dir)
+		return nil, fmt.Errorf("failed to listen on Unix socket: %w", err)
+	}
+
+	s := &Server{
+		dir:         dir,
+		listener:    listener,
+		requests:    make(map[string]int),
+		subscribers: make(map[chan events.Message]struct{}),
+	}
+	s.server = &http.Server{Handler: s.router()}
+	go s.server.Serve(listener)
+	return s, nil
+}
+
+// Host returns the address of Server to be used as the host of Docker API. e.g. `unix:///tmp/dockertest123/docker.sock`
+func (s *Server) Host() string {
+	return "unix://" + s.listener.Addr().String()
+}
+
+// Close stops Server and removes its socket.
+func (s *Server) Close() error {
+	s.mu.Lock()
+	for ch := range s.subscribers {
+		close(ch)
+		delete(s.subscribers, ch)
+	}
+	s.mu.Unlock()
+	err := s.server.Close()
+	return errors.Join(err, os.RemoveAll(s.dir))
+}
+
+// nextID generates an ID of 64 hex digits like the ones of Docker.
+func (s *Server) nextID() string {
+	s.seq++
+	return fmt.Sprintf("%064x", s.seq)
+}
+
+// AddContainer adds c to the containers and returns its ID.
+// The container replaces the existing one with the same ID.
+func (s *Server) AddContainer(c Container) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if c.ID == "" {
+		c.ID = s.nextID()
+	}
+	if c.State == "" {
+		c.State = StateRunning
+	}
+	s.containers = slices.DeleteFunc(s.containers, func(old *Container) bool {
+		return old.ID == c.ID
+	})
+	s.containers = append(s.containers, &c)
+	s.publish(events.ContainerEventType, events.ActionStart, c.ID, map[string]string{"name": c.Name, "image": c.Image})
+	return c.ID
+}
+
+// RemoveContainer removes the container of id. It returns false if the container does not exist.
+func (s *Server) RemoveContainer(id string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	i := slices.IndexFunc(s.containers, func(c *Container) bool {
+		return c.ID == id
+	})
+	if i < 0 {
+		return false
+	}
+	c := s.containers[i]
+	s.containers = slices.Delete(s.containers, i, i+1)
+	s.publish(events.ContainerEventType, events.ActionDestroy, c.ID, map[string]string{"name": c.Name, "image": c.Image})
+	return true
+}
+
+// AddNetwork adds n to the networks and returns its ID.
+func (s *Server) AddNetwork(n Network) string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if n.ID == "" {
+		n.ID = s.nextID()
+	}
+	s.networks = slices.DeleteFunc(s.networks, func(old *Network) bool {
+		return old.ID == n.ID
+	})
+	s.networks = append(s.networks, &n)
+	s.publish(events.NetworkEventType, events.ActionCreate, n.ID, map[string]string{"name": n.Name})
+	return n.ID
+}
+
+// SetFailure makes the list endpoints respond with the status code, to simulate the failures of Docker API.
+// 0 recovers them.
+func (s *Server) SetFailure(code int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failure = code
+}
+
+// Requests returns the number of the requests to the endpoint at path. e.g. `/containers/json`
+// The version prefix of the path is not counted.
+func (s *Server) Requests(path string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[path]
+}
+
+// publish sends the event to the subscribers of `/events`. s.mu must be held.
+func (s *Server) publish(typ events.Type, action events.Action, id string, attributes map[string]string) {
+	now := time.Now()
+	msg := events.Message{
+		Type:     typ,
+		Action:   action,
+		Actor:    events.Actor{ID: id, Attributes: attributes},
+		Scope:    "local",
+		Time:     now.Unix(),
+		TimeNano: now.UnixNano(),
+	}
+	for ch := range s.subscribers {
+		select {
+		case ch <- msg:
+		default:
+			// drop the event for the slow subscriber rather than blocking the test
+		}
+	}
+}
+
+// versionPrefix matches the version prefix of the paths. e.g. `/v1.47`
+var versionPrefix = regexp.MustCompile(`^/v[0-9]+\.[0-9]+`)
+
+func (s *Server) router() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/_ping", s.endpointPing)
+	mux.HandleFunc("/version", s.endpointVersion)
+	mux.HandleFunc("/containers/json", s.endpointContainers)
+	mux.HandleFunc("/networks", s.endpointNetworks)
+	mux.HandleFunc("/events", s.endpointEvents)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.URL.Path = versionPrefix.ReplaceAllString(r.URL.Path, "")
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		s.mu.Unlock()
+		w.Header().Set("Api-Version", APIVersion)
+		w.Header().Set("Ostype", "linux")
+		mux.ServeHTTP(w, r)
+	})
+}
+
+// writeError writes the error in the format of Docker API.
+func writeError(w http.ResponseWriter, code int, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
+
+func writeJSON(w http.ResponseWriter, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(v)
+}
+
+// checkFailure writes the error and returns true if the failure is set by SetFailure.
+func (s *Server) checkFailure(w http.ResponseWriter) bool {
+	s.mu.Lock()
+	failure := s.failure
+	s.mu.Unlock()
+	if failure == 0 {
+		return false
+	}
+	writeError(w, failure, "simulated failure")
+	return true
+}
+
+func (s *Server) endpointPing(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		w.Write([]byte("OK"))
+	}
+}
+
+func (s *Server) endpointVersion(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, &types.Version{
+		Version:       "dockertest",
+		APIVersion:    APIVersion,
+		MinAPIVersion: "1.24",
+		Os:            "linux",
+		Arch:          "amd64",
+	})
+}
+
+// containerFilters are the filters of containers supported by Server.
+var containerFilters = map[string]bool{
+	"id":      true,
+	"name":    true,
+	"label":   true,
+	"status":  true,
+	"network": true,
+}
+
+func (s *Server) endpointContainers(w http.ResponseWriter, r *http.Request) {
+	if s.checkFailure(w) {
+		return
+	}
+	args, err := filters.FromJSON(r.URL.Query().Get("filters"))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	err = args.Validate(containerFilters)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := []*types.Container{}
+	for _, c := range s.containers {
+		if !all && c.State != StateRunning {
+			continue
+		}
+		if !s.matchContainer(c, args) {
+			continue
+		}
+		ret = append(ret, s.toContainer(c))
+	}
+	writeJSON(w, ret)
+}
+
+// matchContainer reports whether c matches args in the same way as Docker. s.mu must be held.
+func (s *Server) matchContainer(c *Container, args filters.Args) bool {
+	if !args.Match("id", c.ID) || !args.Match("name", "/"+c.Name) {
+		return false
+	}
+	if !args.MatchKVList("label", c.Labels) || !args.ExactMatch("status", c.State) {
+		return false
+	}
+	if args.Contains("network") {
+		matched := false
+		for name, ep := range c.Networks {
+			if args.ExactMatch("network", name) || (ep != nil && args.ExactMatch("network", ep.NetworkID)) {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// toContainer converts c into the response of Docker API.
+func (s *Server) toContainer(c *Container) *types.Container {
+	ret := &types.Container{
+		ID:     c.ID,
+		Names:  []string{"/" + c.Name},
+		Image:  c.Image,
+		Labels: c.Labels,
+		State:  c.State,
+		Status: c.State,
+		NetworkSettings: &types.SummaryNetworkSettings{
+			Networks: make(map[string]*network.EndpointSettings, len(c.Networks)),
+		},
+	}
+	ret.HostConfig.NetworkMode = c.NetworkMode
+	for name, ep := range c.Networks {
+		if ep == nil {
+			ret.NetworkSettings.Networks[name] = nil
+			continue
+		}
+		ret.NetworkSettings.Networks[name] = &network.EndpointSettings{
+			NetworkID: ep.NetworkID,
+			IPAddress: ep.IPAddress,
+		}
+	}
+	for _, p := range c.Ports {
+		typ := p.Type
+		if typ == "" {
+			typ = "tcp"
+		}
+		ret.Ports = append(ret.Ports, types.Port{
+			IP:          p.IP,
+			PrivatePort: p.PrivatePort,
+			PublicPort:  p.PublicPort,
+			Type:        typ,
+		})
+	}
+	return ret
+}
+
+func (s *Server) endpointNetworks(w http.ResponseWriter, r *http.Request) {
+	if s.checkFailure(w) {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ret := make([]*network.Summary, 0, len(s.networks))
+	for _, n := range s.networks {
+		ret = append(ret, &network.Summary{
+			ID:       n.ID,
+			Name:     n.Name,
+			Driver:   n.Driver,
+			Scope:    n.Scope,
+			Internal: n.Internal,
+			Labels:   n.Labels,
+		})
+	}
+	writeJSON(w, ret)
+}
+
+// endpointEvents streams the events from now on, until the client disconnects or Server is closed.
+// The filters and `since`/`until` are not supported.
+func (s *Server) endpointEvents(w http.ResponseWriter, r *http.Request) {
+	ch := make(chan events.Message, 64)
+	s.mu.Lock()
+	s.subscribers[ch] = struct{}{}
+	s.mu.Unlock()
+	defer func() {
+		s.mu.Lock()
+		delete(s.subscribers, ch)
+		s.mu.Unlock()
+	}()
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	flusher, _ := w.(http.Flusher)
+	if flusher != nil {
+		flusher.Flush()
+	}
+	enc := json.NewEncoder(w)
+	for {
+		select {
+		case msg, ok := <-ch:
+			if !ok {
+				return
+			}
+			err := enc.Encode(msg)
+			if err != nil {
+				return
+			}
+			if flusher != nil {
+				flusher.Flush()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+}
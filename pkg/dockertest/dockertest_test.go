@@ -0,0 +1,178 @@
+package dockertest
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/events"
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/moby"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/xruins/prommux/pkg/discovery"
+)
+
+// httpClient returns the client to request s over its Unix socket.
+func httpClient(s *Server) *http.Client {
+	return &http.Client{
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				return (&net.Dialer{}).DialContext(ctx, "unix", strings.TrimPrefix(s.Host(), "unix://"))
+			},
+		},
+	}
+}
+
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	s, err := NewServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		s.Close()
+	})
+	return s
+}
+
+// discover runs the Docker discoverer against s and returns the first target groups.
+func discover(t *testing.T, s *Server, filter []moby.Filter, hostNetworkingHost string) []*targetgroup.Group {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(t.Context(), 10*time.Second)
+	defer cancel()
+
+	logger := slog.New(slog.DiscardHandler)
+	d, err := discovery.NewDiscoverer(logger, s.Host(), 9100, filter, time.Minute, hostNetworkingHost, prometheus.NewRegistry(), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Unregister()
+	ch := make(chan []*targetgroup.Group)
+	go d.Run(ctx, ch)
+	select {
+	case tgs := <-ch:
+		return tgs
+	case <-ctx.Done():
+		t.Fatal("timed out to wait for discovery")
+		return nil
+	}
+}
+
+// addresses returns the sorted addresses of the targets.
+func addresses(tgs []*targetgroup.Group) []string {
+	var ret []string
+	for _, tg := range tgs {
+		for _, ls := range tg.Targets {
+			ret = append(ret, string(ls[model.AddressLabel]))
+		}
+	}
+	sort.Strings(ret)
+	return ret
+}
+
+func TestServerDiscovery(t *testing.T) {
+	s := newTestServer(t)
+	networkID := s.AddNetwork(Network{Name: "monitoring", Driver: "bridge", Scope: "local", Labels: map[string]string{"team": "infra"}})
+	s.AddContainer(Container{
+		Name:        "node-exporter",
+		Labels:      map[string]string{"prommux.enabled": "true"},
+		NetworkMode: "monitoring",
+		Networks:    map[string]*Endpoint{"monitoring": {NetworkID: networkID, IPAddress: "172.18.0.2"}},
+		Ports:       []Port{{PrivatePort: 9100}, {PrivatePort: 9101, Type: "udp"}},
+	})
+	s.AddContainer(Container{
+		Name:        "host-exporter",
+		Labels:      map[string]string{"prommux.enabled": "true"},
+		NetworkMode: "host",
+		Networks:    map[string]*Endpoint{"host": {}},
+	})
+	s.AddContainer(Container{
+		Name:     "disabled",
+		Networks: map[string]*Endpoint{"monitoring": {NetworkID: networkID, IPAddress: "172.18.0.3"}},
+	})
+	s.AddContainer(Container{
+		Name:     "stopped",
+		Labels:   map[string]string{"prommux.enabled": "true"},
+		State:    StateExited,
+		Networks: map[string]*Endpoint{"monitoring": {NetworkID: networkID, IPAddress: "172.18.0.4"}},
+	})
+
+	tgs := discover(t, s, []moby.Filter{{Name: "label", Values: []string{"prommux.enabled=true"}}}, "192.0.2.1:9100")
+	want := []string{"172.18.0.2:9100", "192.0.2.1:9100"}
+	if diff := cmp.Diff(want, addresses(tgs)); diff != "" {
+		t.Errorf("unexpected addresses (-want +got):\n%s", diff)
+	}
+	for _, ls := range tgs[0].Targets {
+		if ls["__meta_docker_container_name"] != "/node-exporter" {
+			continue
+		}
+		if got := ls["__meta_docker_network_label_team"]; got != "infra" {
+			t.Errorf("unexpected network label: %s", got)
+		}
+	}
+
+	tgs = discover(t, s, nil, "")
+	if got := len(addresses(tgs)); got != 3 {
+		t.Errorf("unexpected number of targets without filter. got: %d, want: 3", got)
+	}
+	if s.Requests("/containers/json") == 0 || s.Requests("/networks") == 0 {
+		t.Error("the requests must be counted")
+	}
+}
+
+func TestServerFailure(t *testing.T) {
+	s := newTestServer(t)
+	s.SetFailure(http.StatusInternalServerError)
+
+	resp, err := httpClient(s).Get("http://docker/v1.47/containers/json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Errorf("unexpected status code. got: %d, want: %d", resp.StatusCode, http.StatusInternalServerError)
+	}
+
+	s.SetFailure(0)
+	resp, err = httpClient(s).Get(`http://docker/containers/json?filters={"ancestor":["foo"]}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Error("the unsupported filter must be rejected")
+	}
+}
+
+func TestServerEvents(t *testing.T) {
+	s := newTestServer(t)
+
+	resp, err := httpClient(s).Get("http://docker/v1.47/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	id := s.AddContainer(Container{Name: "node-exporter"})
+	s.RemoveContainer(id)
+
+	dec := json.NewDecoder(resp.Body)
+	for _, want := range []events.Action{events.ActionStart, events.ActionDestroy} {
+		var msg events.Message
+		err := dec.Decode(&msg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if msg.Type != events.ContainerEventType || msg.Action != want || msg.Actor.ID != id {
+			t.Errorf("unexpected event: %+v", msg)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// the statuses of targets to filter the response of targets API.
+const (
+	// targetStatusOK is the status of the target whose last scrape succeeded.
+	targetStatusOK = "ok"
+	// targetStatusError is the status of the target whose last scrape failed.
+	targetStatusError = "error"
+	// targetStatusUnknown is the status of the target which has never been scraped.
+	targetStatusUnknown = "unknown"
+	// targetStatusQuarantined is the status of the target which is not served.
+	targetStatusQuarantined = "quarantined"
+)
+
+// defaultAPILimit is the number of targets in a page of targets API if limit is not specified.
+const defaultAPILimit = 100
+
+// ResponseTargets is the response of targets API.
+type ResponseTargets struct {
+	Targets []*ResponseTarget `json:"targets"`
+	// Total is the number of the targets matched, regardless of pagination.
+	Total int `json:"total"`
+	// NextOffset is the offset of the next page. It is omitted on the last page.
+	NextOffset int `json:"next_offset,omitempty"`
+}
+
+// ResponseTarget is a target in the response of targets API.
+type ResponseTarget struct {
+	// Hash and URL are empty if the target is quarantined.
+	Hash       string                     `json:"hash,omitempty"`
+	URL        string                     `json:"url,omitempty"`
+	ProxyPath  string                     `json:"proxy_path,omitempty"`
+	Source     string                     `json:"source"`
+	Status     string                     `json:"status"`
+	Containers []*ResponseTargetContainer `json:"containers"`
+	// Labels are the labels of the target in the response of service discovery.
+	Labels     model.LabelSet `json:"labels,omitempty"`
+	FirstSeen  *time.Time     `json:"first_seen,omitempty"`
+	LastSeen   *time.Time     `json:"last_seen,omitempty"`
+	LastScrape *ScrapeResult  `json:"last_scrape,omitempty"`
+	// QuarantineReason is why the target is not served.
+	QuarantineReason string `json:"quarantine_reason,omitempty"`
+}
+
+// ResponseTargetContainer is a container which exposes a target in the response of targets API.
+type ResponseTargetContainer struct {
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Image string `json:"image,omitempty"`
+	// DiscoveredLabels are the labels retrieved by Docker service discovery.
+	DiscoveredLabels model.LabelSet `json:"discovered_labels"`
+}
+
+// targetsQuery is the query to filter and paginate the response of targets API.
+type targetsQuery struct {
+	hash      string
+	container string
+	status    string
+	labels    model.LabelSet
+	limit     int
+	offset    int
+}
+
+func parseTargetsQuery(values url.Values) (*targetsQuery, error) {
+	q := &targetsQuery{
+		hash:      values.Get("hash"),
+		container: values.Get("container"),
+		status:    values.Get("status"),
+		limit:     defaultAPILimit,
+	}
+	switch q.status {
+	case "", targetStatusOK, targetStatusError, targetStatusUnknown, targetStatusQuarantined:
+	default:
+		return nil, fmt.Errorf("unknown status `%s`", q.status)
+	}
+	for _, l := range values["label"] {
+		name, value, ok := strings.Cut(l, "=")
+		if !ok || name == "" {
+			return nil, fmt.Errorf("invalid label `%s`. must be in the form of name=value", l)
+		}
+		if q.labels == nil {
+			q.labels = model.LabelSet{}
+		}
+		q.labels[model.LabelName(name)] = model.LabelValue(value)
+	}
+	var err error
+	if v := values.Get("limit"); v != "" {
+		q.limit, err = strconv.Atoi(v)
+		if err != nil || q.limit < 0 {
+			return nil, fmt.Errorf("invalid limit `%s`", v)
+		}
+	}
+	if v := values.Get("offset"); v != "" {
+		q.offset, err = strconv.Atoi(v)
+		if err != nil || q.offset < 0 {
+			return nil, fmt.Errorf("invalid offset `%s`", v)
+		}
+	}
+	return q, nil
+}
+
+// match reports whether the target matches the query.
+// The labels match either the discovered labels of any container or the labels of service discovery.
+func (q *targetsQuery) match(t *ResponseTarget) bool {
+	if q.hash != "" && t.Hash != q.hash {
+		return false
+	}
+	if q.status != "" && t.Status != q.status {
+		return false
+	}
+	if q.container != "" && !matchAnyContainer(t.Containers, func(c *ResponseTargetContainer) bool {
+		return matchContainer(c.DiscoveredLabels, q.container)
+	}) {
+		return false
+	}
+	for name, value := range q.labels {
+		if t.Labels[name] == value {
+			continue
+		}
+		if !matchAnyContainer(t.Containers, func(c *ResponseTargetContainer) bool {
+			return c.DiscoveredLabels[name] == value
+		}) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchAnyContainer(containers []*ResponseTargetContainer, fn func(*ResponseTargetContainer) bool) bool {
+	for _, c := range containers {
+		if fn(c) {
+			return true
+		}
+	}
+	return false
+}
+
+func newResponseTargetContainer(ls model.LabelSet) *ResponseTargetContainer {
+	return &ResponseTargetContainer{
+		ID:               string(ls[labelNameContainerID]),
+		Name:             string(ls[labelNameContainerName]),
+		Image:            containerImage(ls),
+		DiscoveredLabels: ls,
+	}
+}
+
+// scrapeStatus returns the status of the target by its last scrape.
+func scrapeStatus(result *ScrapeResult) string {
+	switch {
+	case result == nil:
+		return targetStatusUnknown
+	case result.Error == "" && result.StatusCode >= 200 && result.StatusCode < 300:
+		return targetStatusOK
+	default:
+		return targetStatusError
+	}
+}
+
+// apiTargets returns all the targets of the tenant including the quarantined ones.
+// The served targets are sorted by URL, followed by the quarantined ones. All the targets are returned if tn is nil.
+func (h *Handler) apiTargets(tn *tenant) []*ResponseTarget {
+	entries := make(map[string]model.LabelSet)
+	for _, e := range h.sdSnapshot.Load().entries {
+		entries[e.hash] = e.labels
+	}
+
+	h.targetsMutex.RLock()
+	defer h.targetsMutex.RUnlock()
+
+	var ret []*ResponseTarget
+	for hash, pt := range h.registry.all() {
+		if !tn.allows(hash) {
+			continue
+		}
+		t := &ResponseTarget{
+			Hash:       hash,
+			URL:        pt.url.String(),
+			ProxyPath:  "/proxy/" + hash,
+			Source:     pt.source,
+			Labels:     entries[hash],
+			FirstSeen:  &pt.stats.firstSeen,
+			LastSeen:   pt.stats.lastSeen.Load(),
+			LastScrape: pt.stats.lastScrape.Load(),
+		}
+		t.Status = scrapeStatus(t.LastScrape)
+		for _, ls := range pt.labels {
+			t.Containers = append(t.Containers, newResponseTargetContainer(ls))
+		}
+		ret = append(ret, t)
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		return ret[i].URL < ret[j].URL
+	})
+
+	lastDiscovery := h.lastDiscovery
+	for _, qt := range h.quarantined {
+		if !tn.selects(qt.labels) {
+			continue
+		}
+		ret = append(ret, &ResponseTarget{
+			Source:           qt.source,
+			Status:           targetStatusQuarantined,
+			Containers:       []*ResponseTargetContainer{newResponseTargetContainer(qt.labels)},
+			LastSeen:         &lastDiscovery,
+			QuarantineReason: qt.reason,
+		})
+	}
+	return ret
+}
+
+// endpointAPITargets serves the API to list the targets with their runtime data.
+// The targets are filtered by the query parameters `hash`, `container`, `status` and `label` (name=value, repeatable),
+// and paginated by `limit` and `offset`. `limit=0` returns all the targets.
+func (h *Handler) endpointAPITargets(w http.ResponseWriter, r *http.Request) {
+	q, err := parseTargetsQuery(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid query. err: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	matched := []*ResponseTarget{}
+	for _, t := range h.apiTargets(tenantFromContext(r.Context())) {
+		if q.match(t) {
+			matched = append(matched, t)
+		}
+	}
+	ret := &ResponseTargets{Total: len(matched)}
+	start := min(q.offset, len(matched))
+	end := len(matched)
+	if q.limit > 0 && start+q.limit < end {
+		end = start + q.limit
+		ret.NextOffset = end
+	}
+	ret.Targets = matched[start:end]
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(ret)
+}
+
+// endpointAPIConfig serves the API to show the effective configuration with secrets redacted.
+func (h *Handler) endpointAPIConfig(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.configFor(tenantFromContext(r.Context())))
+}
@@ -0,0 +1,170 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestEndpointAPITargets(t *testing.T) {
+	ctx := t.Context()
+
+	newLabels := func(name, address string) model.LabelSet {
+		return model.LabelSet{
+			labelNameAddressLabel:                  model.LabelValue(address),
+			labelNameContainerID:                   model.LabelValue(name + "-id"),
+			labelNameContainerName:                 model.LabelValue("/" + name),
+			labelNameContainerLabelPrefix + "team": model.LabelValue(name + "-team"),
+		}
+	}
+	broken := newLabels("broken", "broken.example.com")
+	broken[labelNameOverrideMetricsPathLabel] = "{{ .Broken"
+	tg := []*targetgroup.Group{
+		{
+			Source: "test",
+			Targets: []model.LabelSet{
+				newLabels("a", "a.example.com"),
+				newLabels("b", "b.example.com"),
+				newLabels("c", "c.example.com"),
+				broken,
+			},
+		},
+	}
+	handler, err := createTestHandler(t, tg, &HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	// the broken target must be quarantined rather than failing discovery
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type pattern struct {
+		description    string
+		query          string
+		wantCode       int
+		wantContainers []string
+		wantTotal      int
+		wantNextOffset int
+	}
+	patterns := []*pattern{
+		{
+			description:    "all",
+			query:          "",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/a", "/b", "/c", "/broken"},
+			wantTotal:      4,
+		},
+		{
+			description:    "first page",
+			query:          "limit=2",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/a", "/b"},
+			wantTotal:      4,
+			wantNextOffset: 2,
+		},
+		{
+			description:    "last page",
+			query:          "limit=2&offset=2",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/c", "/broken"},
+			wantTotal:      4,
+		},
+		{
+			description:    "out of range",
+			query:          "offset=10",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{},
+			wantTotal:      4,
+		},
+		{
+			description:    "container",
+			query:          "container=/b",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/b"},
+			wantTotal:      1,
+		},
+		{
+			description:    "label",
+			query:          "label=" + string(labelNameContainerLabelPrefix) + "team=c-team",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/c"},
+			wantTotal:      1,
+		},
+		{
+			description:    "quarantined",
+			query:          "status=quarantined",
+			wantCode:       http.StatusOK,
+			wantContainers: []string{"/broken"},
+			wantTotal:      1,
+		},
+		{
+			description: "invalid status",
+			query:       "status=foo",
+			wantCode:    http.StatusBadRequest,
+		},
+		{
+			description: "invalid limit",
+			query:       "limit=-1",
+			wantCode:    http.StatusBadRequest,
+		},
+	}
+
+	for _, p := range patterns {
+		t.Run(p.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/v1/targets?"+p.query, nil)
+			w := httptest.NewRecorder()
+			handler.endpointAPITargets(w, r)
+			res := w.Result()
+			defer res.Body.Close()
+			if res.StatusCode != p.wantCode {
+				t.Fatalf("unexpected status code. got: %d, want: %d", res.StatusCode, p.wantCode)
+			}
+			if p.wantCode != http.StatusOK {
+				return
+			}
+
+			var got ResponseTargets
+			err := json.NewDecoder(res.Body).Decode(&got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Total != p.wantTotal {
+				t.Errorf("unexpected total. got: %d, want: %d", got.Total, p.wantTotal)
+			}
+			if got.NextOffset != p.wantNextOffset {
+				t.Errorf("unexpected next offset. got: %d, want: %d", got.NextOffset, p.wantNextOffset)
+			}
+			containers := []string{}
+			for _, target := range got.Targets {
+				containers = append(containers, target.Containers[0].Name)
+				if target.Status == targetStatusQuarantined {
+					if target.QuarantineReason == "" || target.Hash != "" {
+						t.Errorf("unexpected quarantined target: %+v", target)
+					}
+					continue
+				}
+				if target.FirstSeen == nil || target.LastSeen == nil || target.Source != "test" || target.Labels == nil {
+					t.Errorf("missing runtime data of the target: %+v", target)
+				}
+			}
+			if len(containers) != len(p.wantContainers) {
+				t.Fatalf("unexpected containers. got: %v, want: %v", containers, p.wantContainers)
+			}
+			for i := range containers {
+				if containers[i] != p.wantContainers[i] {
+					t.Errorf("unexpected containers. got: %v, want: %v", containers, p.wantContainers)
+					break
+				}
+			}
+		})
+	}
+}
@@ -10,6 +10,10 @@ var (
 	labelNameOverrideAddressLabel     = model.LabelName(overrideLabelPrefix + overrideLabelAddress)
 	labelNameOverrideMetricsPathLabel = model.LabelName(overrideLabelPrefix + overrideLabelMetricPath)
 	labelNameLabelPrommuxDetectedURL  = model.LabelName(labelPrommuxDetectedURL)
+	// labelNameComposeProject is the label of the compose project of the container.
+	labelNameComposeProject = model.LabelName("__meta_docker_container_label_com_docker_compose_project")
+	// labelNameComposeService is the label of the compose service of the container.
+	labelNameComposeService = model.LabelName("__meta_docker_container_label_com_docker_compose_service")
 )
 
 const (
@@ -28,3 +32,6 @@ const (
 	// labelPrommuxScrapeURL is the name of label to indicate URL to scrape on reverse proxy.
 	labelPrommuxDetectedURL = "prommux_scrape_url"
 )
+
+// LabelScrapeURL is the name of label to indicate URL to scrape on reverse proxy.
+const LabelScrapeURL = labelPrommuxDetectedURL
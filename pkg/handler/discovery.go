@@ -1,19 +1,29 @@
 package handler
 
 import (
-	"encoding/json"
+	"bytes"
 	"fmt"
 	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/prometheus/common/model"
 )
 
-type staticConfig struct {
-	Targets []string       `json:"targets"`
-	Labels  model.LabelSet `json:"labels,omitempty"`
+// StaticConfig is an entry of the response of HTTP service discovery.
+// It is compatible with the format of file_sd as well.
+type StaticConfig struct {
+	Targets []string       `json:"targets" yaml:"targets"`
+	Labels  model.LabelSet `json:"labels,omitempty" yaml:"labels,omitempty"`
 }
 
+// headerDiscoveryVersion is the header to tell the version of the snapshot of service discovery.
+const headerDiscoveryVersion = "X-Prommux-Discovery-Version"
+
 var (
 	filteredLabels = []model.LabelName{
 		model.AddressLabel, model.SchemeLabel, model.MetricsPathLabel,
@@ -22,101 +32,157 @@ var (
 )
 
 // endpointServiceDiscovery serves the endpoint for Docker HTTP service discovery.
+// The response is rendered from the snapshot built on the last update of targets,
+// and supports conditional requests by ETag and Last-Modified.
+// With `shard` and `shards` query parameters, only the targets assigned to the shard are served.
 func (h *Handler) endpointServiceDiscovery(w http.ResponseWriter, r *http.Request) {
-	h.targetsMutex.RLock()
-	ret := make([]*staticConfig, 0, len(h.targets))
-	dedupMap := make(map[string]struct{})
-	for _, tg := range h.targets {
-		for _, ls := range tg.Targets {
-
-			newLabels := ls.Clone()
-
-			url, err := geneateURLFromLabels(newLabels)
-			if err != nil {
-				h.logger.ErrorContext(r.Context(), "failed to generate URL", "error", err)
-				http.Error(
-					w,
-					fmt.Sprintf("failed to generate URL. err: %s", err),
-					http.StatusInternalServerError,
-				)
-				return
-			}
-			hash := endpointHash(url.String())
+	h.serveSDSnapshot(w, r, tenantFromContext(r.Context()).sdSnapshot("", h.sdSnapshot.Load()))
+}
 
-			// dedup targets by TargetURL
-			if _, ok := dedupMap[hash]; ok {
-				continue
-			}
-			dedupMap[hash] = struct{}{}
+// serveSDSnapshot serves the response of service discovery rendered from snapshot.
+func (h *Handler) serveSDSnapshot(w http.ResponseWriter, r *http.Request, snapshot *sdSnapshot) {
+	sh, err := parseShard(r.URL.Query())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("invalid shard. err: %s", err), http.StatusBadRequest)
+		return
+	}
 
-			for _, key := range filteredLabels {
-				delete(newLabels, model.LabelName(key))
-			}
+	// generate URL to scrape metrics
+	scheme := defaultScheme
+	if r.URL.Scheme != "" {
+		scheme = r.URL.Scheme
+	}
+	if r.Header.Get("X-Forwarded-Proto") != "" {
+		scheme = r.Header.Get("X-Forwarded-Proto")
+	}
 
-			// generate URL to scrape metrics
-			scheme := defaultScheme
-			if r.URL.Scheme != "" {
-				scheme = r.URL.Scheme
-			}
+	useGzip := h.discoverGzip && acceptsGzip(r)
+	body, err := snapshot.body(scheme, r.Host, sh, useGzip)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to render service discovery", "error", err)
+		http.Error(
+			w,
+			fmt.Sprintf("failed to render service discovery. err: %s", err),
+			http.StatusInternalServerError,
+		)
+		return
+	}
 
-			address := r.Host
-			if r.Header.Get("X-Forwarded-Proto") != "" {
-				scheme = r.Header.Get("X-Forwarded-Proto")
-			}
+	content, etag := body.raw, body.etag
+	if h.discoverGzip {
+		w.Header().Set("Vary", "Accept-Encoding")
+	}
+	if useGzip {
+		content = body.gzipped
+		// the representations differ, so do the entity tags
+		etag = strings.TrimSuffix(etag, `"`) + `-gzip"`
+		w.Header().Set("Content-Encoding", "gzip")
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("ETag", etag)
+	w.Header().Set(headerDiscoveryVersion, strconv.FormatUint(snapshot.version, 10))
+	http.ServeContent(w, r, "", snapshot.modified, bytes.NewReader(content))
+}
 
-			config := &staticConfig{
-				Targets: []string{address},
-				Labels: model.LabelSet{
-					labelNameMetricsPathLabel: model.LabelValue("/proxy/" + hash),
-					labelNameSchemeLabel:      model.LabelValue(scheme),
-				},
-			}
-			if h.includeDockerLabels {
-				config.Labels = config.Labels.Merge(h.filterLabels(newLabels))
-			}
-			if h.additionalLabels != nil {
-				config.Labels = config.Labels.Merge(h.additionalLabels)
+// acceptsGzip reports whether the client accepts gzip encoding.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc, params, _ := strings.Cut(strings.TrimSpace(enc), ";")
+			if strings.TrimSpace(enc) != "gzip" {
+				continue
 			}
-			config.Labels = config.Labels.Merge(
-				model.LabelSet{
-					labelNameLabelPrommuxDetectedURL: model.LabelValue(url.String()),
-				},
-			)
-
-			ret = append(ret, config)
+			return strings.ReplaceAll(strings.TrimSpace(params), " ", "") != "q=0"
 		}
 	}
-	countTargets := len(ret)
-	h.targetsMutex.RUnlock()
+	return false
+}
 
-	// update Prometheus metrics
-	proxiedEndpointsCountMetrics.Set(float64(countTargets))
-	discoveryLastReloadSuccessfulMetrics.Set(float64(time.Now().Unix()))
+// StaticConfigs returns the current targets in the format of HTTP service discovery.
+// scheme and address are the ones to reach prommux itself, and are written into each entry.
+func (h *Handler) StaticConfigs(scheme, address string) ([]*StaticConfig, error) {
+	return h.sdSnapshot.Load().staticConfigs(scheme, address), nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(ret)
-	return
+// buildSDSnapshot builds the snapshot of service discovery for the targets in the order of hashes.
+// It returns prev as is if the content does not change.
+func (h *Handler) buildSDSnapshot(hashes []string, targets map[string]*proxyTarget, prev *sdSnapshot) (*sdSnapshot, error) {
+	entries := make([]*sdEntry, 0, len(hashes))
+	for _, hash := range hashes {
+		pt := targets[hash]
+		// the labels of the first container are used for the deduplicated targets
+		config := h.newStaticConfig(pt.labels[0].Clone(), pt.url, hash, "", "")
+		delete(config.Labels, labelNameSchemeLabel)
+		entries = append(entries, &sdEntry{
+			hash:     hash,
+			labels:   config.Labels,
+			project:  string(pt.labels[0][labelNameComposeProject]),
+			shardKey: h.shardKeyOf(hash, pt),
+		})
+	}
+	return newSDSnapshot(entries, prev, time.Now())
+}
+
+// newStaticConfig creates the entry of service discovery for a target.
+// The labels in ls may be deleted.
+func (h *Handler) newStaticConfig(ls model.LabelSet, u *url.URL, hash, scheme, address string) *StaticConfig {
+	for _, key := range filteredLabels {
+		delete(ls, model.LabelName(key))
+	}
+	var kept model.LabelSet
+	if h.includeDockerLabels {
+		kept = h.filterLabels(ls)
+	}
+	return buildStaticConfig(kept, h.additionalLabels, u, hash, scheme, address)
+}
+
+// buildStaticConfig creates the entry of service discovery for a target
+// with the Docker labels kept by the filter and the additional labels.
+func buildStaticConfig(kept, additionalLabels model.LabelSet, u *url.URL, hash, scheme, address string) *StaticConfig {
+	config := &StaticConfig{
+		Targets: []string{address},
+		Labels: model.LabelSet{
+			labelNameMetricsPathLabel: model.LabelValue("/proxy/" + hash),
+			labelNameSchemeLabel:      model.LabelValue(scheme),
+		},
+	}
+	if kept != nil {
+		config.Labels = config.Labels.Merge(kept)
+	}
+	if additionalLabels != nil {
+		config.Labels = config.Labels.Merge(additionalLabels)
+	}
+	config.Labels = config.Labels.Merge(
+		model.LabelSet{
+			labelNameLabelPrommuxDetectedURL: model.LabelValue(u.String()),
+		},
+	)
+	return config
 }
 
 // filterLabels filters LabelSet with regexp defined in regexpDockerLabels.
 // If `includeDockerLabels` is false or regexpDockerLabels is nil,
 // it returns original LabelSet as is.
 func (h *Handler) filterLabels(labels model.LabelSet) model.LabelSet {
-	if !h.includeDockerLabels || h.regexpDockerLabels == nil {
+	if !h.includeDockerLabels {
 		return labels
 	}
-	if h.regexpMatchCache == nil {
-		h.regexpMatchCache = make(map[string]bool, len(labels))
-	}
+	return filterLabelsByRegexp(labels, h.regexpDockerLabels, &h.regexpMatchCache)
+}
 
+// filterLabelsByRegexp returns the labels whose names match re, caching the results of matching in cache.
+// If re is nil, it returns original LabelSet as is.
+func filterLabelsByRegexp(labels model.LabelSet, re *regexp.Regexp, cache *sync.Map) model.LabelSet {
+	if re == nil {
+		return labels
+	}
 	var newLabelSet model.LabelSet
 	for name, value := range labels {
 		s := string(name)
 		// check cache and use its result if found
-		cacheMatched, ok := h.regexpMatchCache[string(s)]
+		cacheMatched, ok := cache.Load(s)
 		if ok {
-			if cacheMatched {
+			if cacheMatched.(bool) {
 				if newLabelSet == nil {
 					newLabelSet = model.LabelSet{}
 				}
@@ -125,8 +191,8 @@ func (h *Handler) filterLabels(labels model.LabelSet) model.LabelSet {
 			continue
 		}
 		// check a label with regexp and cache result
-		matched := h.regexpDockerLabels.MatchString(s)
-		h.regexpMatchCache[s] = matched
+		matched := re.MatchString(s)
+		cache.Store(s, matched)
 		if matched {
 			if newLabelSet == nil {
 				newLabelSet = model.LabelSet{}
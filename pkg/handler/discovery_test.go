@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"io"
 	"net/http"
@@ -18,7 +19,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 		description  string
 		params       *HandlerParams
 		tg           []*targetgroup.Group
-		wantResponse []*staticConfig
+		wantResponse []*StaticConfig
 		wantCode     int
 	}
 
@@ -31,7 +32,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 				},
 			},
 			tg: []*targetgroup.Group{testTargetGroup},
-			wantResponse: []*staticConfig{
+			wantResponse: []*StaticConfig{
 				{
 					Targets: []string{"example.com"},
 					Labels: model.LabelSet{
@@ -54,7 +55,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 				},
 			},
 			tg: []*targetgroup.Group{testTargetGroup},
-			wantResponse: []*staticConfig{
+			wantResponse: []*StaticConfig{
 				{
 					Targets: []string{"example.com"},
 					Labels: model.LabelSet{
@@ -76,7 +77,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 				},
 			},
 			tg: []*targetgroup.Group{testTargetGroup},
-			wantResponse: []*staticConfig{
+			wantResponse: []*StaticConfig{
 				{
 					Targets: []string{"example.com"},
 					Labels: model.LabelSet{
@@ -99,7 +100,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 				AdditionalLabels: `{"foofoo":"barbar"}`,
 			},
 			tg: []*targetgroup.Group{testTargetGroup},
-			wantResponse: []*staticConfig{
+			wantResponse: []*StaticConfig{
 				{
 					Targets: []string{"example.com"},
 					Labels: model.LabelSet{
@@ -121,16 +122,12 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			readyCh := make(chan bool, 1)
-			handler.isReady.subscribe(readyCh)
 			go func() {
 				handler.Run(ctx)
 			}()
-			for {
-				v := <-readyCh
-				if v {
-					break
-				}
+			err = handler.WaitReady(ctx)
+			if err != nil {
+				t.Fatal(err)
 			}
 
 			r := httptest.NewRequest(http.MethodGet, "/discovery", nil)
@@ -142,7 +139,7 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 			if err != nil {
 				t.Fatal(err)
 			}
-			var got []*staticConfig
+			var got []*StaticConfig
 			err = json.Unmarshal(data, &got)
 			if err != nil {
 				t.Fatal(err)
@@ -153,3 +150,95 @@ func TestEndpointServiceDiscovery(t *testing.T) {
 		})
 	}
 }
+
+func TestEndpointServiceDiscoveryConditional(t *testing.T) {
+	ctx := t.Context()
+	handler, err := createTestHandler(t, []*targetgroup.Group{testTargetGroup}, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		DiscoverGzip:     true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	request := func(header http.Header) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/discover", nil)
+		for k, v := range header {
+			r.Header[k] = v
+		}
+		w := httptest.NewRecorder()
+		handler.endpointServiceDiscovery(w, r)
+		return w.Result()
+	}
+
+	res := request(nil)
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected status code. got: %d, want: %d", res.StatusCode, http.StatusOK)
+	}
+	etag := res.Header.Get("ETag")
+	if etag == "" {
+		t.Fatal("ETag is missing")
+	}
+	if res.Header.Get("Last-Modified") == "" {
+		t.Error("Last-Modified is missing")
+	}
+	version := res.Header.Get(headerDiscoveryVersion)
+
+	res = request(http.Header{"If-None-Match": {etag}})
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("unexpected status code with If-None-Match. got: %d, want: %d", res.StatusCode, http.StatusNotModified)
+	}
+
+	// the same targets must not change the snapshot
+	err = handler.updateTargets(ctx, []*targetgroup.Group{testTargetGroup})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = request(http.Header{"If-None-Match": {etag}})
+	if res.StatusCode != http.StatusNotModified {
+		t.Errorf("unexpected status code after no-op update. got: %d, want: %d", res.StatusCode, http.StatusNotModified)
+	}
+	if got := res.Header.Get(headerDiscoveryVersion); got != version {
+		t.Errorf("unexpected version after no-op update. got: %s, want: %s", got, version)
+	}
+
+	res = request(http.Header{"Accept-Encoding": {"gzip"}})
+	if got := res.Header.Get("Content-Encoding"); got != "gzip" {
+		t.Fatalf("unexpected Content-Encoding. got: %s, want: gzip", got)
+	}
+	if res.Header.Get("ETag") == etag {
+		t.Error("ETag of the gzip variant must differ")
+	}
+	gz, err := gzip.NewReader(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []*StaticConfig
+	err = json.NewDecoder(gz).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 {
+		t.Errorf("unexpected number of entries. got: %d, want: 1", len(got))
+	}
+
+	// the change of targets must change ETag
+	err = handler.updateTargets(ctx, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res = request(http.Header{"If-None-Match": {etag}})
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code after update. got: %d, want: %d", res.StatusCode, http.StatusOK)
+	}
+	if got := res.Header.Get(headerDiscoveryVersion); got == version {
+		t.Errorf("the version must change after update. got: %s", got)
+	}
+}
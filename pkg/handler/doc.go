@@ -0,0 +1,11 @@
+// Package handler serves the targets discovered by the target sources
+// as HTTP service discovery for Prometheus, and proxies the scrapes to them.
+//
+// The package can be embedded in other programs.
+// NewHandler creates Handler, which discovers the targets from Docker API by default.
+// Any source of the targets can be plugged in by implementing TargetSource and passing it with WithTargetSources.
+// Handler.Run runs the sources, and Handler.NewRouter returns the router serving the endpoints.
+//
+// The exported identifiers of this package are stable.
+// They are changed only in a backward compatible way, except for the major version updates.
+package handler
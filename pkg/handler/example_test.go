@@ -0,0 +1,60 @@
+package handler_test
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+
+	"github.com/xruins/prommux/pkg/handler"
+)
+
+// staticSource is a TargetSource which serves the fixed targets.
+type staticSource []*targetgroup.Group
+
+func (s staticSource) Run(ctx context.Context, ch chan<- []*targetgroup.Group) error {
+	select {
+	case ch <- s:
+	case <-ctx.Done():
+		return nil
+	}
+	<-ctx.Done()
+	return nil
+}
+
+func ExampleNewHandler() {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	source := staticSource{{
+		Source:  "static",
+		Targets: []model.LabelSet{{model.AddressLabel: "node-exporter:9100"}},
+	}}
+	reg := prometheus.NewRegistry()
+	h, err := handler.NewHandler(
+		&handler.HandlerParams{},
+		handler.WithTargetSources(source),
+		handler.WithRegistry(reg, reg),
+		handler.WithLogger(slog.New(slog.DiscardHandler)),
+	)
+	if err != nil {
+		panic(err)
+	}
+	go h.Run(ctx)
+	if err := h.WaitReady(ctx); err != nil {
+		panic(err)
+	}
+
+	// serve h.NewRouter() to let Prometheus discover the targets
+	configs, err := h.StaticConfigs("http", "prommux:8080")
+	if err != nil {
+		panic(err)
+	}
+	for _, config := range configs {
+		fmt.Println(config.Targets)
+	}
+	// Output: [prommux:8080]
+}
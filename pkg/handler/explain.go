@@ -0,0 +1,178 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+)
+
+// Explanation describes how the labels of a target become the URL to scrape and the entry of service discovery.
+type Explanation struct {
+	ContainerID   string `json:"container_id"`
+	ContainerName string `json:"container_name"`
+	// MetaLabels are the labels of the target retrieved by Docker service discovery.
+	MetaLabels     model.LabelSet                `json:"meta_labels"`
+	TemplateParams *OverrideLabelsTemplateParams `json:"template_params,omitempty"`
+	// Overrides are the override labels applied to the URL, in the order of application.
+	Overrides []*ExplainOverride `json:"overrides"`
+	URL       string             `json:"url,omitempty"`
+	Hash      string             `json:"hash,omitempty"`
+	Dedup     *ExplainDedup      `json:"dedup,omitempty"`
+	Filtering *ExplainFiltering  `json:"filtering,omitempty"`
+	// StaticConfig is the entry of service discovery. It is nil if the target is deduplicated or failed.
+	StaticConfig *StaticConfig `json:"static_config,omitempty"`
+	Error        string        `json:"error,omitempty"`
+}
+
+// ExplainOverride is an override label applied to the URL.
+type ExplainOverride struct {
+	Label    string `json:"label"`
+	Template string `json:"template"`
+	Before   string `json:"before"`
+	After    string `json:"after"`
+	Error    string `json:"error,omitempty"`
+}
+
+// ExplainDedup is the result of deduplication by URL.
+type ExplainDedup struct {
+	// Kept is true if the target is the first one for the URL and served.
+	Kept bool `json:"kept"`
+	// DuplicateOf is the container of the target served instead.
+	DuplicateOf string `json:"duplicate_of,omitempty"`
+}
+
+// ExplainFiltering is the result of filtering Docker labels.
+type ExplainFiltering struct {
+	IncludeDockerLabels bool     `json:"include_docker_labels"`
+	Regexp              string   `json:"regexp,omitempty"`
+	Kept                []string `json:"kept"`
+	Dropped             []string `json:"dropped"`
+}
+
+// matchContainer reports whether id is the ID, the prefix of ID or the name of the container.
+func matchContainer(ls model.LabelSet, id string) bool {
+	containerID := string(ls[labelNameContainerID])
+	if containerID != "" && strings.HasPrefix(containerID, id) {
+		return true
+	}
+	name := string(ls[labelNameContainerName])
+	return name != "" && strings.TrimPrefix(name, "/") == strings.TrimPrefix(id, "/")
+}
+
+// Explain explains how the targets of the container become the entries of service discovery.
+// id is the ID, the prefix of ID or the name of the container.
+// scheme and address are the ones to reach prommux itself.
+func (h *Handler) Explain(id, scheme, address string) []*Explanation {
+	return h.explain(id, scheme, address, nil)
+}
+
+// explain explains the targets of the container which the tenant can see, or all the targets if t is nil.
+// The targets shared with the other tenants are left out as in service discovery,
+// and the containers of the other tenants are not told as the ones deduplicated into.
+func (h *Handler) explain(id, scheme, address string, t *tenant) []*Explanation {
+	h.targetsMutex.RLock()
+	defer h.targetsMutex.RUnlock()
+
+	var ret []*Explanation
+	// the labels of the container of the first target for each URL
+	firstContainers := make(map[string]model.LabelSet)
+	for _, tg := range h.targets {
+		for _, ls := range tg.Targets {
+			matched := matchContainer(ls, id) && t.selects(ls)
+			trace := &urlTrace{}
+			u, err := traceURLFromLabels(ls, trace)
+			if !matched {
+				if err == nil {
+					hash := endpointHash(u.String())
+					if _, ok := firstContainers[hash]; !ok {
+						firstContainers[hash] = ls
+					}
+				}
+				continue
+			}
+
+			e := &Explanation{
+				ContainerID:    string(ls[labelNameContainerID]),
+				ContainerName:  string(ls[labelNameContainerName]),
+				MetaLabels:     ls,
+				TemplateParams: trace.params,
+				Overrides:      trace.overrides,
+			}
+			ret = append(ret, e)
+			if err != nil {
+				e.Error = err.Error()
+				continue
+			}
+			e.URL = u.String()
+			e.Hash = endpointHash(e.URL)
+
+			if first, ok := firstContainers[e.Hash]; ok {
+				e.Dedup = &ExplainDedup{}
+				if t.selects(first) {
+					e.Dedup.DuplicateOf = string(first[labelNameContainerName])
+				}
+				continue
+			}
+			firstContainers[e.Hash] = ls
+			e.Dedup = &ExplainDedup{Kept: true}
+
+			newLabels := ls.Clone()
+			e.StaticConfig = h.newStaticConfig(newLabels, u, e.Hash, scheme, address)
+			e.Filtering = h.explainFiltering(newLabels)
+		}
+	}
+	return slices.DeleteFunc(ret, func(e *Explanation) bool {
+		return e.Hash != "" && !t.allows(e.Hash)
+	})
+}
+
+// explainFiltering returns the result of filterLabels for ls.
+func (h *Handler) explainFiltering(ls model.LabelSet) *ExplainFiltering {
+	ret := &ExplainFiltering{
+		IncludeDockerLabels: h.includeDockerLabels,
+		Kept:                []string{},
+		Dropped:             []string{},
+	}
+	if h.regexpDockerLabels != nil {
+		ret.Regexp = h.regexpDockerLabels.String()
+	}
+	var kept model.LabelSet
+	if h.includeDockerLabels {
+		kept = h.filterLabels(ls)
+	}
+	for name := range ls {
+		if _, ok := kept[name]; ok {
+			ret.Kept = append(ret.Kept, string(name))
+		} else {
+			ret.Dropped = append(ret.Dropped, string(name))
+		}
+	}
+	sort.Strings(ret.Kept)
+	sort.Strings(ret.Dropped)
+	return ret
+}
+
+// endpointExplain serves the endpoint to explain how the targets of a container are generated.
+func (h *Handler) endpointExplain(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	scheme := defaultScheme
+	if r.Header.Get("X-Forwarded-Proto") != "" {
+		scheme = r.Header.Get("X-Forwarded-Proto")
+	}
+	explanations := h.explain(id, scheme, r.Host, tenantFromContext(r.Context()))
+	if len(explanations) == 0 {
+		http.Error(w, fmt.Sprintf("no target found for the container `%s`", id), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(explanations)
+}
@@ -0,0 +1,103 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestExplain(t *testing.T) {
+	ctx := t.Context()
+
+	first := model.LabelSet{
+		labelNameAddressLabel:         "172.17.0.2:9100",
+		labelNameContainerID:          "aaaaaaaaaaaa",
+		labelNameContainerName:        "/first",
+		labelNameOverrideAddressLabel: "exporter:{{ .OriginalPort }}",
+	}
+	second := model.LabelSet{
+		labelNameAddressLabel:         "172.17.0.3:9100",
+		labelNameContainerID:          "bbbbbbbbbbbb",
+		labelNameContainerName:        "/second",
+		labelNameOverrideAddressLabel: "exporter:{{ .OriginalPort }}",
+		"foo":                         "bar",
+	}
+	tg := []*targetgroup.Group{{Targets: []model.LabelSet{first, second}}}
+	handler, err := createTestHandler(t, tg, &HandlerParams{
+		DiscovererParams: &DiscovererParams{
+			IncludeDockerLabels: true,
+			RegexpDockerLabels:  "^foo$",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	url := "http://exporter:9100/metrics"
+	hash := endpointHash(url)
+	params := &OverrideLabelsTemplateParams{
+		OriginalHost:        "172.17.0.2",
+		OriginalPort:        "9100",
+		OriginalMetricsPath: defaultMetricPath,
+	}
+	wantFirst := []*Explanation{
+		{
+			ContainerID:    "aaaaaaaaaaaa",
+			ContainerName:  "/first",
+			MetaLabels:     first,
+			TemplateParams: params,
+			Overrides: []*ExplainOverride{
+				{
+					Label:    string(labelNameOverrideAddressLabel),
+					Template: "exporter:{{ .OriginalPort }}",
+					Before:   "172.17.0.2:9100",
+					After:    "exporter:9100",
+				},
+			},
+			URL:  url,
+			Hash: hash,
+			Dedup: &ExplainDedup{
+				Kept: true,
+			},
+			Filtering: &ExplainFiltering{
+				IncludeDockerLabels: true,
+				Regexp:              "^foo$",
+				Kept:                []string{},
+				Dropped:             []string{string(labelNameContainerID), string(labelNameContainerName)},
+			},
+			StaticConfig: &StaticConfig{
+				Targets: []string{"localhost"},
+				Labels: model.LabelSet{
+					labelNameSchemeLabel:             "http",
+					labelNameMetricsPathLabel:        model.LabelValue("/proxy/" + hash),
+					labelNameLabelPrommuxDetectedURL: model.LabelValue(url),
+				},
+			},
+		},
+	}
+	got := handler.Explain("aaaa", "http", "localhost")
+	if diff := cmp.Diff(got, wantFirst); diff != "" {
+		t.Errorf("unexpected explanation for the first container. diff(-got, +want): %s", diff)
+	}
+
+	got = handler.Explain("second", "http", "localhost")
+	if len(got) != 1 {
+		t.Fatalf("unexpected number of explanations. got: %d, want: 1", len(got))
+	}
+	wantDedup := &ExplainDedup{DuplicateOf: "/first"}
+	if diff := cmp.Diff(got[0].Dedup, wantDedup); diff != "" {
+		t.Errorf("unexpected dedup for the second container. diff(-got, +want): %s", diff)
+	}
+	if got[0].StaticConfig != nil {
+		t.Errorf("the deduplicated target must not have static config. got: %v", got[0].StaticConfig)
+	}
+}
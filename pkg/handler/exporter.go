@@ -1,51 +1,204 @@
 package handler
 
-import "github.com/prometheus/client_golang/prometheus"
+import (
+	"fmt"
+	"net/http"
 
-const (
-	metricsPrefix = "prommux_"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/collectors/version"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 )
 
-var (
-	proxiedEndpointsCountMetrics = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: metricsPrefix + "proxied_endpoints_count",
-			Help: "Number of proxied exporter endpoints",
-		},
-	)
-	proxiedEndpointsHTTPStatusMetrics = prometheus.NewGaugeVec(
-		prometheus.GaugeOpts{
-			Name: metricsPrefix + "http_requests_total",
-			Help: "Counter of requests made to the HTTP endpoints.",
-		},
-		[]string{"code", "handler", "method", "path"},
-	)
-	discoveryLastReloadSuccessfulMetrics = prometheus.NewGauge(
-		prometheus.GaugeOpts{
-			Name: metricsPrefix + "prommux_discovery_updated_count_successful",
-			Help: "Whether the last reload of Docker discovery succeeded or not",
-		},
-	)
-	proxySuccessCountMetrics = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricsPrefix + "proxy_success_count",
-			Help: "Count of succeeded requests of proxy endpoint",
-		},
-	)
-	proxyFailureCountMetrics = prometheus.NewCounter(
-		prometheus.CounterOpts{
-			Name: metricsPrefix + "proxy_failure_count",
-			Help: "Count of failed requests of proxy endpoint",
-		},
+// metricsNamespace is the namespace of the metrics of prommux.
+const metricsNamespace = "prommux"
+
+// metrics is the set of the metrics of Handler.
+type metrics struct {
+	// targets is the number of the targets served by the reverse proxy.
+	targets prometheus.Gauge
+	// quarantinedTargets is the number of the targets discovered but not served.
+	quarantinedTargets prometheus.Gauge
+	// discoveryUpdates is the count of the updates of targets by discovery.
+	discoveryUpdates prometheus.Counter
+	// discoveryLastUpdate is the timestamp of the last update of targets by discovery.
+	discoveryLastUpdate prometheus.Gauge
+	discovererRestarts  prometheus.Counter
+	componentState      *prometheus.GaugeVec
+
+	// the metrics of the HTTP endpoints, instrumented by promhttp.
+	httpRequests         *prometheus.CounterVec
+	httpRequestDuration  *prometheus.HistogramVec
+	httpRequestsInFlight prometheus.Gauge
+
+	// the metrics of the reverse proxy per target.
+	proxyTargetRequests     *prometheus.CounterVec
+	proxyTargetDuration     *prometheus.HistogramVec
+	proxyTargetResponseSize *prometheus.HistogramVec
+	proxyTargetErrors       *prometheus.CounterVec
+
+	// the metrics of writing file_sd files.
+	fileSDWriteFailures prometheus.Counter
+	fileSDLastWrite     prometheus.Gauge
+
+	// the metrics of the usage per tenant.
+	tenantTargets      *prometheus.GaugeVec
+	tenantRequests     *prometheus.CounterVec
+	tenantResponseSize *prometheus.CounterVec
+	tenantUnauthorized prometheus.Counter
+}
+
+// newMetrics creates the metrics and registers them on reg.
+func newMetrics(reg prometheus.Registerer) (*metrics, error) {
+	m := &metrics{
+		targets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "targets",
+			Help:      "Number of the exporter endpoints served by the reverse proxy",
+		}),
+		quarantinedTargets: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "quarantined_targets",
+			Help:      "Number of the targets discovered but not served since their URL could not be generated",
+		}),
+		discoveryUpdates: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "discovery_updates_total",
+			Help:      "Count of the updates of targets by Docker discovery",
+		}),
+		discoveryLastUpdate: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "discovery_last_update_timestamp_seconds",
+			Help:      "Timestamp of the last update of targets by Docker discovery",
+		}),
+		discovererRestarts: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "discoverer_restarts_total",
+			Help:      "Count of restarts of the target sources after they exited",
+		}),
+		componentState: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "component_state",
+			Help:      "Whether the component is in the state (1) or not (0)",
+		}, []string{"component", "state"}),
+		httpRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_total",
+			Help:      "Count of requests to the HTTP endpoints",
+		}, []string{"handler", "code", "method"}),
+		httpRequestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_request_duration_seconds",
+			Help:      "Latency of the HTTP endpoints",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"handler", "method"}),
+		httpRequestsInFlight: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "http_requests_in_flight",
+			Help:      "Number of the requests being served by the HTTP endpoints",
+		}),
+		proxyTargetRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "proxy_target_requests_total",
+			Help:      "Count of requests of proxy endpoint per target by the class of status code",
+		}, []string{"hash", "container", "code_class"}),
+		proxyTargetDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "proxy_target_request_duration_seconds",
+			Help:      "Latency of the upstream exporter per target",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"hash", "container"}),
+		proxyTargetResponseSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: metricsNamespace,
+			Name:      "proxy_target_response_size_bytes",
+			Help:      "Size of the response body of the upstream exporter per target",
+			Buckets:   prometheus.ExponentialBuckets(256, 4, 8),
+		}, []string{"hash", "container"}),
+		proxyTargetErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "proxy_target_errors_total",
+			Help:      "Count of errors to request the upstream exporter per target by reason",
+		}, []string{"hash", "container", "reason"}),
+		fileSDWriteFailures: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "file_sd_write_failures_total",
+			Help:      "Count of failures to write the targets to file_sd files",
+		}),
+		fileSDLastWrite: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "file_sd_last_write_timestamp_seconds",
+			Help:      "Timestamp of the last successful write of the targets to file_sd files",
+		}),
+		tenantTargets: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_targets",
+			Help:      "Number of the targets which each tenant can see and scrape",
+		}, []string{"tenant"}),
+		tenantRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_requests_total",
+			Help:      "Count of the requests of each tenant by endpoint and status code",
+		}, []string{"tenant", "handler", "code"}),
+		tenantResponseSize: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_response_bytes_total",
+			Help:      "Total size of the response bodies sent to each tenant by endpoint",
+		}, []string{"tenant", "handler"}),
+		tenantUnauthorized: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "tenant_unauthorized_requests_total",
+			Help:      "Count of the requests rejected since they have no valid bearer token of tenants",
+		}),
+	}
+
+	for _, c := range []prometheus.Collector{
+		m.targets,
+		m.quarantinedTargets,
+		m.discoveryUpdates,
+		m.discoveryLastUpdate,
+		m.discovererRestarts,
+		m.componentState,
+		m.httpRequests,
+		m.httpRequestDuration,
+		m.httpRequestsInFlight,
+		m.proxyTargetRequests,
+		m.proxyTargetDuration,
+		m.proxyTargetResponseSize,
+		m.proxyTargetErrors,
+		m.fileSDWriteFailures,
+		m.fileSDLastWrite,
+		m.tenantTargets,
+		m.tenantRequests,
+		m.tenantResponseSize,
+		m.tenantUnauthorized,
+		version.NewCollector(metricsNamespace),
+	} {
+		err := reg.Register(c)
+		if err != nil {
+			return nil, fmt.Errorf("failed to register metrics: %w", err)
+		}
+	}
+	return m, nil
+}
+
+// newDefaultRegistry creates the registry used if none is given, with the collectors of Go runtime and the process.
+func newDefaultRegistry() *prometheus.Registry {
+	reg := prometheus.NewRegistry()
+	reg.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
 	)
-)
+	return reg
+}
 
-func init() {
-	prometheus.MustRegister(
-		proxiedEndpointsCountMetrics,
-		proxiedEndpointsHTTPStatusMetrics,
-		discoveryLastReloadSuccessfulMetrics,
-		proxySuccessCountMetrics,
-		proxyFailureCountMetrics,
+// instrument instruments the HTTP endpoint named name with the metrics of requests.
+func (m *metrics) instrument(name string, handler http.HandlerFunc) http.Handler {
+	labels := prometheus.Labels{"handler": name}
+	return promhttp.InstrumentHandlerInFlight(
+		m.httpRequestsInFlight,
+		promhttp.InstrumentHandlerCounter(
+			m.httpRequests.MustCurryWith(labels),
+			promhttp.InstrumentHandlerDuration(m.httpRequestDuration.MustCurryWith(labels), handler),
+		),
 	)
 }
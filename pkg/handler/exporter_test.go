@@ -0,0 +1,83 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+func TestHandlerRegisterer(t *testing.T) {
+	// handlers with their own registries coexist
+	for i := 0; i < 2; i++ {
+		_, err := createHandlerByParams(&HandlerParams{DiscovererParams: &DiscovererParams{}})
+		if err != nil {
+			t.Fatalf("failed to create handler with the default registry: %s", err)
+		}
+	}
+
+	reg := prometheus.NewRegistry()
+	_, err := createHandlerByParams(&HandlerParams{DiscovererParams: &DiscovererParams{}, Registerer: reg})
+	if err != nil {
+		t.Fatalf("failed to create handler with the registry: %s", err)
+	}
+	_, err = createHandlerByParams(&HandlerParams{DiscovererParams: &DiscovererParams{}, Registerer: reg})
+	if err == nil {
+		t.Error("handlers must fail to register the metrics on the same registry twice")
+	}
+
+	// handlers can share a registry by labeling their metrics
+	shared := prometheus.NewRegistry()
+	for _, name := range []string{"first", "second"} {
+		_, err = createHandlerByParams(&HandlerParams{
+			DiscovererParams: &DiscovererParams{},
+			Registerer:       prometheus.WrapRegistererWith(prometheus.Labels{"handler_name": name}, shared),
+			Gatherer:         shared,
+		})
+		if err != nil {
+			t.Errorf("failed to create handler `%s` with the wrapped registry: %s", name, err)
+		}
+	}
+}
+
+func TestEndpointMetrics(t *testing.T) {
+	handler, err := createTestHandler(t, nil, &HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(handler.NewRouter())
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "/-/healthy")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+
+	res, err = server.Client().Get(server.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(data)
+	for _, want := range []string{
+		"prommux_build_info{",
+		`prommux_http_requests_total{code="200",handler="healthy",method="get"} 1`,
+		"prommux_http_request_duration_seconds_bucket{",
+		"go_goroutines ",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("the metrics do not contain `%s`", want)
+		}
+	}
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code. got: %d, want: %d", res.StatusCode, http.StatusOK)
+	}
+}
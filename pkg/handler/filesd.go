@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// the formats of file_sd files.
+const (
+	FileSDFormatJSON = "json"
+	FileSDFormatYAML = "yaml"
+)
+
+// FileSDParams is the parameters to write the targets to file_sd files, as an alternative to HTTP service discovery.
+type FileSDParams struct {
+	// Path is the file to write. e.g. `/etc/prometheus/targets/prommux.json`
+	Path string `json:"path"`
+	// Format is either `json` or `yaml`. It is guessed by the extension of Path if empty.
+	Format string `json:"format"`
+	// Scheme and Address are how Prometheus reaches prommux, written in each target.
+	Scheme  string `json:"scheme"`
+	Address string `json:"address"`
+	// SplitByProject writes the targets of each compose project into its own file named `<name>_<project><ext>` beside Path.
+	// The targets out of compose projects are written to Path.
+	SplitByProject bool `json:"split_by_project"`
+	// RetryInterval is the interval to retry writing the files after failures, besides each update of the targets.
+	// It is 10 seconds if zero.
+	RetryInterval time.Duration `json:"retry_interval"`
+}
+
+// defaultFileSDRetryInterval is the interval to retry writing file_sd files when FileSDParams.RetryInterval is zero.
+const defaultFileSDRetryInterval = 10 * time.Second
+
+// invalidFileNameChars matches the characters not to be used in the names of file_sd files.
+var invalidFileNameChars = regexp.MustCompile(`[^A-Za-z0-9_.-]`)
+
+// fileSDWriter writes the snapshot of service discovery to file_sd files.
+// It is called only from the goroutine updating the targets.
+type fileSDWriter struct {
+	params *FileSDParams
+	// digest is the digest of the snapshot written last.
+	digest string
+	// written is the files written so far, to remove the ones of the projects which have gone.
+	// The files failed to be written or removed are kept, since the ones written before may remain.
+	written map[string]struct{}
+}
+
+func newFileSDWriter(params *FileSDParams) (*fileSDWriter, error) {
+	p := *params
+	if p.Path == "" {
+		return nil, errors.New("path of file_sd must be specified")
+	}
+	if p.Address == "" {
+		return nil, errors.New("address to write in file_sd must be specified")
+	}
+	if p.Scheme == "" {
+		p.Scheme = defaultScheme
+	}
+	if p.RetryInterval <= 0 {
+		p.RetryInterval = defaultFileSDRetryInterval
+	}
+	if p.Format == "" {
+		switch filepath.Ext(p.Path) {
+		case ".yml", ".yaml":
+			p.Format = FileSDFormatYAML
+		default:
+			p.Format = FileSDFormatJSON
+		}
+	}
+	if p.Format != FileSDFormatJSON && p.Format != FileSDFormatYAML {
+		return nil, fmt.Errorf("unknown format of file_sd `%s`. (candidates: json, yaml)", p.Format)
+	}
+	return &fileSDWriter{params: &p, written: make(map[string]struct{})}, nil
+}
+
+// upToDate reports whether the snapshot has been written to the files.
+func (w *fileSDWriter) upToDate(s *sdSnapshot) bool {
+	return s.digest == w.digest
+}
+
+// write writes the snapshot to the files unless it has been written already.
+func (w *fileSDWriter) write(s *sdSnapshot) error {
+	if w.upToDate(s) {
+		return nil
+	}
+
+	files := map[string][]*StaticConfig{
+		w.params.Path: {},
+	}
+	configs := s.staticConfigs(w.params.Scheme, w.params.Address)
+	for i, e := range s.entries {
+		path := w.params.Path
+		if w.params.SplitByProject && e.project != "" {
+			path = w.projectPath(e.project)
+		}
+		files[path] = append(files[path], configs[i])
+	}
+
+	var errs []error
+	written := make(map[string]struct{}, len(files))
+	for path, configs := range files {
+		err := w.writeFile(path, configs)
+		if err != nil {
+			errs = append(errs, err)
+			if _, ok := w.written[path]; ok {
+				written[path] = struct{}{}
+			}
+			continue
+		}
+		written[path] = struct{}{}
+	}
+	for path := range w.written {
+		if _, ok := files[path]; ok {
+			continue
+		}
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, fmt.Errorf("failed to remove file_sd `%s`: %w", path, err))
+			written[path] = struct{}{}
+		}
+	}
+	w.written = written
+	if len(errs) > 0 {
+		return errors.Join(errs...)
+	}
+	w.digest = s.digest
+	return nil
+}
+
+// projectPath returns the path of the file for the compose project.
+func (w *fileSDWriter) projectPath(project string) string {
+	ext := filepath.Ext(w.params.Path)
+	stem := strings.TrimSuffix(w.params.Path, ext)
+	return stem + "_" + invalidFileNameChars.ReplaceAllString(project, "_") + ext
+}
+
+// writeFile writes configs to path atomically, by renaming the temporary file written beside it.
+func (w *fileSDWriter) writeFile(path string, configs []*StaticConfig) error {
+	var buf bytes.Buffer
+	switch w.params.Format {
+	case FileSDFormatYAML:
+		enc := yaml.NewEncoder(&buf)
+		enc.SetIndent(2)
+		err := enc.Encode(configs)
+		if err == nil {
+			err = enc.Close()
+		}
+		if err != nil {
+			return fmt.Errorf("failed to encode file_sd `%s`: %w", path, err)
+		}
+	default:
+		enc := json.NewEncoder(&buf)
+		enc.SetIndent("", "  ")
+		err := enc.Encode(configs)
+		if err != nil {
+			return fmt.Errorf("failed to encode file_sd `%s`: %w", path, err)
+		}
+	}
+
+	// the temporary file does not match the patterns of file_sd like `*.json`
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file for file_sd `%s`: %w", path, err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(buf.Bytes())
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file for file_sd `%s`: %w", path, err)
+	}
+	err = os.Rename(tmp.Name(), path)
+	if err != nil {
+		return fmt.Errorf("failed to rename temporary file to file_sd `%s`: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,228 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"gopkg.in/yaml.v3"
+)
+
+// composeTarget returns the labels of a target in the compose project.
+func composeTarget(address, project string) model.LabelSet {
+	ls := model.LabelSet{labelNameAddressLabel: model.LabelValue(address)}
+	if project != "" {
+		ls[labelNameComposeProject] = model.LabelValue(project)
+	}
+	return ls
+}
+
+func readFileSD(t *testing.T, path string) []*StaticConfig {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var configs []*StaticConfig
+	if filepath.Ext(path) == ".yml" {
+		err = yaml.Unmarshal(data, &configs)
+	} else {
+		err = json.Unmarshal(data, &configs)
+	}
+	if err != nil {
+		t.Fatalf("failed to parse %s: %s", path, err)
+	}
+	return configs
+}
+
+func TestFileSD(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prommux.json")
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		FileSD:           &FileSDParams{Path: path, Address: "prommux:11298"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{composeTarget("a:9100", "web"), composeTarget("b:9100", "")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	configs := readFileSD(t, path)
+	if len(configs) != 2 {
+		t.Fatalf("unexpected number of targets. got: %d, want: 2", len(configs))
+	}
+	for _, c := range configs {
+		if len(c.Targets) != 1 || c.Targets[0] != "prommux:11298" || c.Labels[labelNameSchemeLabel] != "http" {
+			t.Errorf("unexpected target: %+v", c)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("the temporary files must be removed. got: %v", entries)
+	}
+}
+
+func TestFileSDSplitByProject(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prommux.yml")
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		FileSD:           &FileSDParams{Path: path, Address: "prommux:11298", SplitByProject: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{
+			composeTarget("a:9100", "web"),
+			composeTarget("b:9100", "web"),
+			composeTarget("c:9100", "db/primary"),
+			composeTarget("d:9100", ""),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for file, want := range map[string]int{
+		"prommux.yml":            1,
+		"prommux_web.yml":        2,
+		"prommux_db_primary.yml": 1,
+	} {
+		if got := len(readFileSD(t, filepath.Join(dir, file))); got != want {
+			t.Errorf("unexpected number of targets in %s. got: %d, want: %d", file, got, want)
+		}
+	}
+
+	// the file of the project which has gone is removed
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{composeTarget("a:9100", "web")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "prommux_db_primary.yml")); !os.IsNotExist(err) {
+		t.Errorf("the file of the removed project must be removed. err: %v", err)
+	}
+	if got := len(readFileSD(t, path)); got != 0 {
+		t.Errorf("the file out of projects must be kept empty. got: %d targets", got)
+	}
+}
+
+func TestFileSDRemoveAfterFailure(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "prommux.json")
+	dbPath := filepath.Join(dir, "prommux_db.json")
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		FileSD:           &FileSDParams{Path: path, Address: "prommux:11298", SplitByProject: true},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	update := func(targets ...model.LabelSet) error {
+		return h.updateTargets(context.Background(), []*targetgroup.Group{{Targets: targets}})
+	}
+
+	err = update(composeTarget("a:9100", "web"), composeTarget("b:9100", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the file of db cannot be replaced by a directory at its path
+	err = os.Remove(dbPath)
+	if err == nil {
+		err = os.Mkdir(dbPath, 0o755)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = update(composeTarget("a:9100", "web"), composeTarget("c:9100", "db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h.fileSD.upToDate(h.sdSnapshot.Load()) {
+		t.Fatal("the write of the file of db must fail")
+	}
+
+	// the file of db left by the failure is removed when the project has gone
+	err = update(composeTarget("a:9100", "web"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(dbPath); !os.IsNotExist(err) {
+		t.Errorf("the file of the removed project must be removed after the failure. err: %v", err)
+	}
+}
+
+func TestFileSDRetry(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	// the directory does not exist until the first write fails
+	dir := filepath.Join(t.TempDir(), "targets")
+	path := filepath.Join(dir, "prommux.json")
+	h, err := createTestHandler(t, []*targetgroup.Group{{
+		Targets: []model.LabelSet{composeTarget("a:9100", "")},
+	}}, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		FileSD:           &FileSDParams{Path: path, Address: "prommux:11298", RetryInterval: 50 * time.Millisecond},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go h.Run(ctx)
+	err = h.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("the first write must fail. err: %v", err)
+	}
+
+	err = os.Mkdir(dir, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	timeout := time.After(10 * time.Second)
+	for {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		select {
+		case <-timeout:
+			t.Fatal("the write of file_sd must be retried without updates of the targets")
+		case <-time.After(20 * time.Millisecond):
+		}
+	}
+	if got := len(readFileSD(t, path)); got != 1 {
+		t.Errorf("unexpected number of targets. got: %d, want: 1", got)
+	}
+}
+
+func TestNewFileSDWriter(t *testing.T) {
+	for name, params := range map[string]*FileSDParams{
+		"no path":        {Address: "prommux:11298"},
+		"no address":     {Path: "prommux.json"},
+		"unknown format": {Path: "prommux.json", Address: "prommux:11298", Format: "toml"},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newFileSDWriter(params)
+			if err == nil {
+				t.Error("error must be returned")
+			}
+		})
+	}
+}
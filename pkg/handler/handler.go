@@ -2,15 +2,19 @@ package handler
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"log/slog"
-	"net/http/httputil"
+	"net/http"
 	"net/url"
 	"regexp"
+	"slices"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/prometheus/common/model"
 	"github.com/prometheus/prometheus/discovery/moby"
@@ -18,33 +22,75 @@ import (
 	"github.com/xruins/prommux/pkg/discovery"
 )
 
-type discoverer interface {
-	Run(ctx context.Context) error
-}
-
+// Handler discovers the targets, and serves the endpoints of service discovery and the reverse proxy to them.
 type Handler struct {
-	discoverer                      discoverer
-	targets                         []*targetgroup.Group
-	targetsMutex                    sync.RWMutex
-	proxies                         map[string]*url.URL
-	ch                              <-chan []*targetgroup.Group
+	sources      []TargetSource
+	targets      []*targetgroup.Group
+	targetsMutex sync.RWMutex
+	// quarantined are the targets which are discovered but not served.
+	quarantined []*quarantinedTarget
+	// lastDiscovery is the time when the targets are received last.
+	lastDiscovery                   time.Time
+	registry                        *proxyRegistry
 	discovererTimeout, proxyTimeout time.Duration
-	includeDockerLabels             bool
-	additionalLabels                model.LabelSet
-	regexpDockerLabels              *regexp.Regexp
-	regexpMatchCache                map[string]bool
-	logger                          slog.Logger
-	reverseProxyMap                 map[url.URL]*httputil.ReverseProxy
-	config                          *HandlerParams
-	isReady                         notifiableAtomicBool
+	// staleAfter is the age of the last discovery to regard it as stale.
+	staleAfter          time.Duration
+	includeDockerLabels bool
+	additionalLabels    model.LabelSet
+	regexpDockerLabels  *regexp.Regexp
+	regexpMatchCache    sync.Map
+	logger              slog.Logger
+	config              *HandlerParams
+	states              *stateBroadcaster
+	// sdSnapshot is the snapshot of the response of service discovery for the current targets.
+	sdSnapshot   atomic.Pointer[sdSnapshot]
+	discoverGzip bool
+	// shardKey is the key to assign the targets to shards. (see ShardKey of HandlerParams)
+	shardKey   string
+	metrics    *metrics
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	// fileSD writes the targets to file_sd files if not nil.
+	fileSD *fileSDWriter
+	// views are the named views of service discovery keyed by their names.
+	views map[string]*view
+	// tenants are the tenants allowed to access. Every request is allowed if empty.
+	tenants []*tenant
+	// metricsToken is the bearer token required for `/metrics`. It is public if empty.
+	metricsToken []byte
 }
 
 // HandlerParam is the parameters to configure Handler.
 type HandlerParams struct {
-	Logger           slog.Logger       `json:"-"`
-	ProxyTimeout     time.Duration     `json:"proxy_timeout"`
-	DiscovererParams *DiscovererParams `json:"discoverer_params"`
-	AdditionalLabels string            `json:"additional_labels,string"`
+	Logger slog.Logger `json:"-"`
+	// Registerer is where the metrics of Handler are registered, and Gatherer is what `/metrics` serves.
+	// A new registry is used for both if Registerer is nil.
+	// If Gatherer is nil, Registerer is used if it is a Gatherer, or prometheus.DefaultGatherer otherwise.
+	Registerer       prometheus.Registerer `json:"-"`
+	Gatherer         prometheus.Gatherer   `json:"-"`
+	ProxyTimeout     time.Duration         `json:"proxy_timeout"`
+	DiscovererParams *DiscovererParams     `json:"discoverer_params"`
+	AdditionalLabels string                `json:"additional_labels,string"`
+	// ProxyMetricsMaxTargets is the maximum number of targets to have their own series of proxy metrics.
+	// The rest share the series labeled `_other`. It is 500 if zero, and the per-target metrics are disabled if negative.
+	ProxyMetricsMaxTargets int `json:"proxy_metrics_max_targets"`
+	// DiscoverGzip enables gzip encoding of the response of service discovery for the clients accepting it.
+	DiscoverGzip bool `json:"discover_gzip"`
+	// ShardKey is the key to assign the targets to the shards requested by `shard` and `shards` query parameters of service discovery.
+	// It is one of ShardKeyHash, ShardKeyContainer and ShardKeyService. ShardKeyHash is used if empty.
+	ShardKey string `json:"shard_key,omitempty"`
+	// Views are the named views of service discovery, each of which is served at `/discover/{name}`.
+	Views []*ViewParams `json:"views,omitempty"`
+	// Tenants restrict the targets each request can see and scrape to the ones of the tenant with its bearer token.
+	Tenants []*TenantParams `json:"tenants,omitempty"`
+	// MetricsBearerToken is required for `/metrics` if not empty. It is read from MetricsBearerTokenFile if empty.
+	// `/metrics` is not restricted by tenants, and exposes the names of tenants and the labels of all the containers.
+	MetricsBearerToken     string `json:"metrics_bearer_token,omitempty"`
+	MetricsBearerTokenFile string `json:"metrics_bearer_token_file,omitempty"`
+	// FileSD writes the targets to file_sd files as well, if not nil.
+	FileSD *FileSDParams `json:"file_sd,omitempty"`
+	// FlagSources is where the value of each flag came from. (flag, env, config or default)
+	FlagSources map[string]string `json:"flag_sources,omitempty"`
 }
 
 // DiscovererParams is the parameters to configure Discoverer.
@@ -57,21 +103,85 @@ type DiscovererParams struct {
 	RegexpDockerLabels  string        `json:"regexp_docker_labels"`
 	HostNetworkingHost  string        `json:"host_networking_host"`
 	Filter              []moby.Filter `json:"filter"`
+	// StaleFactor is the multiple of RefreshInterval to regard the last discovery as stale. 0 disables it.
+	StaleFactor float64 `json:"stale_factor"`
+}
+
+// redacted returns the copy of params with the secrets redacted, to be shown to users.
+func (p *HandlerParams) redacted() *HandlerParams {
+	ret := *p
+	if p.DiscovererParams != nil {
+		dp := *p.DiscovererParams
+		// the address of Docker API may have the credentials
+		if u, err := url.Parse(dp.Host); err == nil && u.User != nil {
+			dp.Host = u.Redacted()
+		}
+		ret.DiscovererParams = &dp
+	}
+	if ret.MetricsBearerToken != "" {
+		ret.MetricsBearerToken = redactedSecret
+	}
+	if len(p.Tenants) > 0 {
+		ret.Tenants = make([]*TenantParams, 0, len(p.Tenants))
+		for _, t := range p.Tenants {
+			tp := *t
+			if tp.BearerToken != "" {
+				tp.BearerToken = redactedSecret
+			}
+			ret.Tenants = append(ret.Tenants, &tp)
+		}
+	}
+	return &ret
+}
+
+// loggedHandlerParams is HandlerParams without LogValue, not to resolve the value endlessly.
+type loggedHandlerParams HandlerParams
+
+// LogValue implements slog.LogValuer, so that the secrets are redacted in logs.
+func (p *HandlerParams) LogValue() slog.Value {
+	return slog.AnyValue((*loggedHandlerParams)(p.redacted()))
 }
 
 func createHandlerByParams(params *HandlerParams) (*Handler, error) {
+	if params.DiscovererParams == nil {
+		params.DiscovererParams = &DiscovererParams{}
+	}
+	registerer, gatherer := params.Registerer, params.Gatherer
+	if registerer == nil {
+		reg := newDefaultRegistry()
+		registerer, gatherer = reg, reg
+	}
+	if gatherer == nil {
+		var ok bool
+		gatherer, ok = registerer.(prometheus.Gatherer)
+		if !ok {
+			gatherer = prometheus.DefaultGatherer
+		}
+	}
+	m, err := newMetrics(registerer)
+	if err != nil {
+		return nil, err
+	}
+
 	h := &Handler{
-		proxies:             make(map[string]*url.URL),
+		registry:            newProxyRegistry(newProxyFunc(nil), newProxyMetrics(m, params.ProxyMetricsMaxTargets)),
+		metrics:             m,
+		registerer:          registerer,
+		gatherer:            gatherer,
 		discovererTimeout:   params.DiscovererParams.DiscovererTimeout,
 		proxyTimeout:        params.ProxyTimeout,
 		includeDockerLabels: params.DiscovererParams.IncludeDockerLabels,
-		regexpMatchCache:    make(map[string]bool),
+		discoverGzip:        params.DiscoverGzip,
+		shardKey:            ShardKeyHash,
 		logger:              params.Logger,
-		reverseProxyMap:     make(map[url.URL]*httputil.ReverseProxy),
 		config:              params,
+		states:              newStateBroadcaster(componentDiscovery, componentServer),
+		staleAfter:          time.Duration(params.DiscovererParams.StaleFactor * float64(params.DiscovererParams.RefreshInterval)),
 	}
 
-	var err error
+	h.states.Set(componentServer, StateReady, "")
+	h.sdSnapshot.Store(&sdSnapshot{modified: time.Now()})
+
 	if al := params.AdditionalLabels; al != "" {
 		var labelSet model.LabelSet
 		err = labelSet.UnmarshalJSON([]byte(al))
@@ -81,6 +191,35 @@ func createHandlerByParams(params *HandlerParams) (*Handler, error) {
 		h.additionalLabels = labelSet
 	}
 
+	if params.ShardKey != "" {
+		err = validateShardKey(params.ShardKey)
+		if err != nil {
+			return nil, err
+		}
+		h.shardKey = params.ShardKey
+	}
+
+	h.views, err = newViews(params.Views)
+	if err != nil {
+		return nil, fmt.Errorf("invalid views: %w", err)
+	}
+	h.tenants, err = newTenants(params.Tenants)
+	if err != nil {
+		return nil, fmt.Errorf("invalid tenants: %w", err)
+	}
+	metricsToken, err := readBearerToken(params.MetricsBearerToken, params.MetricsBearerTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bearer token for metrics: %w", err)
+	}
+	h.metricsToken = []byte(metricsToken)
+
+	if params.FileSD != nil {
+		h.fileSD, err = newFileSDWriter(params.FileSD)
+		if err != nil {
+			return nil, fmt.Errorf("invalid file_sd parameters: %w", err)
+		}
+	}
+
 	if regexpDockerLabels := params.DiscovererParams.RegexpDockerLabels; regexpDockerLabels != "" {
 		h.regexpDockerLabels, err = regexp.Compile(regexpDockerLabels)
 		if err != nil {
@@ -92,85 +231,349 @@ func createHandlerByParams(params *HandlerParams) (*Handler, error) {
 }
 
 // NewHandler creates a new instance on Handler and returns it.
-func NewHandler(params *HandlerParams) (*Handler, error) {
-	h, err := createHandlerByParams(params)
+// The targets are discovered from Docker API configured by params.DiscovererParams,
+// unless the target sources are given by WithTargetSources.
+func NewHandler(params *HandlerParams, opts ...Option) (*Handler, error) {
+	o := &handlerOptions{}
+	for _, opt := range opts {
+		opt(o)
+	}
+	var p HandlerParams
+	if params != nil {
+		p = *params
+	}
+	if o.registerer != nil {
+		p.Registerer, p.Gatherer = o.registerer, o.gatherer
+	}
+	if o.logger != nil {
+		p.Logger = *o.logger
+	}
+
+	h, err := createHandlerByParams(&p)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process handlerParams: %w", err)
 	}
-	ch := make(chan []*targetgroup.Group, 1)
-	h.ch = ch
-	h.discoverer = discovery.NewDiscoverer(
-		&params.Logger,
-		params.DiscovererParams.Host,
-		params.DiscovererParams.Port,
-		params.DiscovererParams.Filter,
-		params.DiscovererParams.RefreshInterval,
-		params.DiscovererParams.HostNetworkingHost,
-		ch,
-	)
-
-	h.logger.Debug("initialized Handler", slog.Any("params", params))
+	if o.httpClient != nil {
+		h.registry.newProxy = newProxyFunc(o.httpClient.Transport)
+	}
+
+	h.sources = o.sources
+	if len(h.sources) == 0 {
+		d, err := discovery.NewDiscoverer(
+			&p.Logger,
+			p.DiscovererParams.Host,
+			p.DiscovererParams.Port,
+			p.DiscovererParams.Filter,
+			p.DiscovererParams.RefreshInterval,
+			p.DiscovererParams.HostNetworkingHost,
+			h.registerer,
+			// the address of Docker API may have the credentials, which must not be in the labels of metrics
+			p.redacted().DiscovererParams.Host,
+		)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create discoverer: %w", err)
+		}
+		h.sources = []TargetSource{d}
+	}
+
+	h.logger.Debug("initialized Handler", slog.Any("params", &p))
 	return h, nil
 }
 
-// Run receives TargetGroups from discoverer and update reverse proxy periodically.
+// Run runs the target sources and updates the targets on each update from them until ctx is done.
+// The targets of all the sources are merged, and discovery gets ready once every source has sent its targets.
 func (h *Handler) Run(ctx context.Context) error {
-	errCh := make(chan error, 1)
-	go func() {
-		err := h.discoverer.Run(ctx)
-		errCh <- err
+	if len(h.sources) == 0 {
+		return errors.New("no target sources")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	defer func() {
+		cancel()
+		wg.Wait()
+		h.unregisterSources()
 	}()
+	updates := make(chan *sourceUpdate)
+	for i, src := range h.sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.runSource(ctx, i, src, updates)
+		}()
+	}
+	go h.exportStates(ctx)
+
+	// check staleness of discovery periodically
+	var staleCh <-chan time.Time
+	if h.staleAfter > 0 {
+		ticker := time.NewTicker(h.staleAfter / 2)
+		defer ticker.Stop()
+		staleCh = ticker.C
+	}
+	// retry writing file_sd files after failures
+	var fileSDRetryCh <-chan time.Time
+	if h.fileSD != nil {
+		ticker := time.NewTicker(h.fileSD.params.RetryInterval)
+		defer ticker.Stop()
+		fileSDRetryCh = ticker.C
+	}
+	// the latest target groups of each source
+	latest := make([][]*targetgroup.Group, len(h.sources))
+	reported := make([]bool, len(h.sources))
+	// the errors of the sources being restarted.
+	// they are degraded instead of failed, since the sources may recover on restart.
+	failures := make(map[int]error)
 	for {
 		select {
-		case v := <-h.ch:
+		case u := <-updates:
+			if u.err != nil {
+				failures[u.index] = u.err
+				h.states.Set(componentDiscovery, StateDegraded, u.err.Error())
+				continue
+			}
 			h.logger.DebugContext(
 				ctx,
 				"received target groups",
-				slog.Any("target_group", v),
+				slog.Int("source", u.index),
+				slog.Any("target_group", u.tgs),
 			)
-			err := func() error {
-				h.targetsMutex.Lock()
-				defer h.targetsMutex.Unlock()
-				h.targets = v
-				for _, tg := range v {
-					for _, target := range tg.Targets {
-						u, err := geneateURLFromLabels(target)
-						if err != nil {
-							return fmt.Errorf("failed to generate URL for `%s`: %w", target, err)
-						}
-						hash := endpointHash(u.String())
-						h.proxies[hash] = u
-						h.logger.DebugContext(
-							ctx,
-							"registered endpoint",
-							slog.String("url", u.String()),
-							slog.String("hash", hash),
-							slog.Any("target", target),
-						)
-					}
-				}
-				return nil
-			}()
+			latest[u.index] = u.tgs
+			reported[u.index] = true
+			delete(failures, u.index)
+			err := h.updateTargets(ctx, slices.Concat(latest...))
 			if err != nil {
+				h.states.Set(componentDiscovery, StateFailed, err.Error())
 				return err
 			}
-			h.isReady.Store(true)
+			for _, err := range failures {
+				h.states.Set(componentDiscovery, StateDegraded, err.Error())
+			}
+			if len(failures) == 0 && !slices.Contains(reported, false) {
+				h.states.Set(componentDiscovery, StateReady, "")
+			}
+		case now := <-staleCh:
+			h.checkStaleness(now)
+		case now := <-fileSDRetryCh:
+			h.writeFileSD(ctx, h.sdSnapshot.Load(), now)
 		case <-ctx.Done():
 			return nil
-		case err := <-errCh:
-			h.isReady.Store(false)
-			return fmt.Errorf("an error occured when executing docker discoverer: %w", err)
 		}
 	}
 }
 
+// unregisterSources unregisters the metrics of the target sources if they have.
+func (h *Handler) unregisterSources() {
+	for _, src := range h.sources {
+		if u, ok := src.(metricsUnregisterer); ok {
+			u.Unregister()
+		}
+	}
+}
+
+// updateTargets replaces the targets with the target groups received from the discoverer.
+// The targets whose URL cannot be generated are quarantined instead of being served.
+func (h *Handler) updateTargets(ctx context.Context, tgs []*targetgroup.Group) error {
+	proxies := make(map[string]*proxyTarget)
+	// hashes keeps the order of targets for the response of service discovery
+	var hashes []string
+	var quarantined []*quarantinedTarget
+	for _, tg := range tgs {
+		for _, target := range tg.Targets {
+			u, err := geneateURLFromLabels(target)
+			if err != nil {
+				h.logger.WarnContext(
+					ctx,
+					"quarantined target since failed to generate URL",
+					slog.Any("target", target),
+					slog.Any("error", err),
+				)
+				quarantined = append(quarantined, &quarantinedTarget{
+					source: tg.Source,
+					labels: target,
+					reason: fmt.Sprintf("failed to generate URL: %s", err),
+				})
+				continue
+			}
+			hash := endpointHash(u.String())
+			pt, ok := proxies[hash]
+			if !ok {
+				pt = &proxyTarget{url: u, hash: hash, source: tg.Source}
+				proxies[hash] = pt
+				hashes = append(hashes, hash)
+			}
+			pt.labels = append(pt.labels, target)
+			h.logger.DebugContext(
+				ctx,
+				"registered endpoint",
+				slog.String("url", u.String()),
+				slog.String("hash", hash),
+				slog.Any("target", target),
+			)
+		}
+	}
+
+	// updates are serialized by Run, so the previous snapshot is not replaced concurrently
+	snapshot, err := h.buildSDSnapshot(hashes, proxies, h.sdSnapshot.Load())
+	if err != nil {
+		return fmt.Errorf("failed to build the snapshot of service discovery: %w", err)
+	}
+	viewSnapshots, err := h.buildViewSDSnapshots(hashes, proxies)
+	if err != nil {
+		return err
+	}
+	var tenantStates []*tenantState
+	if len(h.tenants) > 0 {
+		snapshots := map[string]*sdSnapshot{"": snapshot}
+		for name, s := range viewSnapshots {
+			snapshots[name] = s
+		}
+		tenantStates, err = h.buildTenantStates(proxies, snapshots)
+		if err != nil {
+			return err
+		}
+	}
+
+	now := time.Now()
+	h.targetsMutex.Lock()
+	h.targets = tgs
+	h.quarantined = quarantined
+	h.registry.replace(proxies, now)
+	// publish the snapshots after the targets, so that every target served is ready to be proxied
+	h.sdSnapshot.Store(snapshot)
+	for name, s := range viewSnapshots {
+		h.views[name].sdSnapshot.Store(s)
+	}
+	h.publishTenantStates(tenantStates)
+	h.lastDiscovery = now
+	h.targetsMutex.Unlock()
+
+	// update Prometheus metrics
+	h.metrics.targets.Set(float64(len(hashes)))
+	h.metrics.quarantinedTargets.Set(float64(len(quarantined)))
+	h.metrics.discoveryUpdates.Inc()
+	h.metrics.discoveryLastUpdate.Set(float64(now.Unix()))
+
+	h.writeFileSD(ctx, snapshot, now)
+	return nil
+}
+
+// writeFileSD writes the snapshot to file_sd files if enabled.
+// It is retried by Run periodically on failures, and does nothing once the snapshot is written.
+func (h *Handler) writeFileSD(ctx context.Context, snapshot *sdSnapshot, now time.Time) {
+	if h.fileSD == nil || h.fileSD.upToDate(snapshot) {
+		return
+	}
+	err := h.fileSD.write(snapshot)
+	if err != nil {
+		h.logger.ErrorContext(ctx, "failed to write file_sd", slog.Any("error", err))
+		h.metrics.fileSDWriteFailures.Inc()
+		return
+	}
+	h.metrics.fileSDLastWrite.Set(float64(now.Unix()))
+}
+
+// checkStaleness marks discovery as degraded if the last discovery is older than staleAfter.
+func (h *Handler) checkStaleness(now time.Time) {
+	if h.staleAfter <= 0 || h.states.Get(componentDiscovery).State != StateReady {
+		return
+	}
+	h.targetsMutex.RLock()
+	lastDiscovery := h.lastDiscovery
+	h.targetsMutex.RUnlock()
+	if now.Sub(lastDiscovery) > h.staleAfter {
+		h.states.Set(componentDiscovery, StateDegraded, fmt.Sprintf("the last discovery is older than %s", h.staleAfter))
+	}
+}
+
+// exportStates updates the metrics for the states of components until ctx is done.
+func (h *Handler) exportStates(ctx context.Context) {
+	ch, unsubscribe := h.states.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case statuses := <-ch:
+			for component, status := range statuses {
+				for state := range componentStateNames {
+					v := 0.0
+					if state == status.State {
+						v = 1
+					}
+					h.metrics.componentState.WithLabelValues(component, state.String()).Set(v)
+				}
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// States returns the statuses of the components of Handler.
+func (h *Handler) States() ComponentStatuses {
+	return h.states.Snapshot()
+}
+
+// SubscribeStates returns the channel to receive the statuses of the components on each update,
+// starting with the current one, and the function to unsubscribe.
+// Updates are coalesced if the receiver is slower than them.
+func (h *Handler) SubscribeStates() (<-chan ComponentStatuses, func()) {
+	return h.states.Subscribe()
+}
+
+// WaitReady blocks until Handler receives the targets from the discoverer for the first time.
+// It returns an error if ctx is done or discovery fails before that.
+// The discoverer being restarted after an error is waited for, until ctx is done.
+func (h *Handler) WaitReady(ctx context.Context) error {
+	ch, unsubscribe := h.states.Subscribe()
+	defer unsubscribe()
+	for {
+		select {
+		case statuses := <-ch:
+			switch status := statuses[componentDiscovery]; status.State {
+			case StateStarting, StateDegraded:
+				// the sources being restarted may succeed later
+				continue
+			case StateFailed:
+				return fmt.Errorf("discovery failed: %s", status.Message)
+			default:
+				return nil
+			}
+		case <-ctx.Done():
+			return fmt.Errorf("gave up waiting for the first discovery: %w", ctx.Err())
+		}
+	}
+}
+
+// SetShuttingDown marks Handler as not ready permanently.
+// It is called at the beginning of graceful shutdown.
+func (h *Handler) SetShuttingDown() {
+	h.states.Set(componentServer, StateDegraded, "shutting down")
+}
+
 // NewRouTer creates *mux.Router and returns it.
+// The endpoints are instrumented with the metrics of requests, labeled with the name of each endpoint.
 func (h *Handler) NewRouter() *mux.Router {
 	r := mux.NewRouter()
-	r.HandleFunc("/discover", h.endpointServiceDiscovery)
-	r.HandleFunc("/proxy/{source}", h.endpointProxy)
-	r.HandleFunc("/status", h.endpointStatus)
-	r.HandleFunc("/-/health", h.endpointHealth)
-	r.Handle("/metrics", promhttp.Handler())
+	handle := func(path, name string, handler http.HandlerFunc) {
+		r.Handle(path, h.metrics.instrument(name, h.withTenant(name, handler)))
+	}
+	// the endpoints for health checks and monitoring do not need the tokens of tenants
+	handlePublic := func(path, name string, handler http.HandlerFunc) {
+		r.Handle(path, h.metrics.instrument(name, handler))
+	}
+	handle("/discover", "discover", h.endpointServiceDiscovery)
+	handle("/discover/{view}", "discover_view", h.endpointViewServiceDiscovery)
+	handle("/proxy/{source}", "proxy", h.endpointProxy)
+	handle("/status", "status", h.endpointStatus)
+	handlePublic("/-/health", "ready", h.endpointReady)
+	handlePublic("/-/healthy", "healthy", h.endpointHealthy)
+	handlePublic("/-/ready", "ready", h.endpointReady)
+	handle("/debug/explain/{id}", "explain", h.endpointExplain)
+	handle("/api/v1/targets", "api_targets", h.endpointAPITargets)
+	handle("/api/v1/config", "api_config", h.endpointAPIConfig)
+	// the base URL is `/prometheus` for the clients of Prometheus API, to avoid conflicting with the API of prommux
+	handle("/prometheus/api/v1/targets", "prometheus_targets", h.endpointPrometheusTargets)
+	handlePublic("/metrics", "metrics", h.withMetricsToken(promhttp.HandlerFor(h.gatherer, promhttp.HandlerOpts{}).ServeHTTP))
+	h.handleUI(r, handle, handlePublic)
 	return r
 }
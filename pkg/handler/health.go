@@ -1,18 +1,104 @@
 package handler
 
 import (
-	"fmt"
+	"encoding/json"
 	"net/http"
+	"time"
 )
 
-// endpointHealth serves the endpoint for health check.
-func (h *Handler) endpointHealth(w http.ResponseWriter, r *http.Request) {
-	ok := h.isReady.Load()
-	if !ok {
-		fmt.Println("respond ng")
-		http.Error(w, "healthcheck failed: handler is not ready", http.StatusServiceUnavailable)
-		return
+const (
+	healthStatusOK   = "ok"
+	healthStatusFail = "fail"
+)
+
+// ResponseHealth is the response of health endpoints.
+type ResponseHealth struct {
+	Status     string                              `json:"status"`
+	Components map[string]*ResponseHealthComponent `json:"components"`
+}
+
+// ResponseHealthComponent is the state of a component in the response of health endpoints.
+type ResponseHealthComponent struct {
+	Status      string         `json:"status"`
+	State       ComponentState `json:"state"`
+	Message     string         `json:"message,omitempty"`
+	Since       time.Time      `json:"since"`
+	LastRefresh *time.Time     `json:"last_refresh,omitempty"`
+}
+
+// healthComponents converts the statuses of components into the response.
+// The component is ok if ok(its state) is true.
+func (h *Handler) healthComponents(statuses ComponentStatuses, ok func(ComponentState) bool) map[string]*ResponseHealthComponent {
+	ret := make(map[string]*ResponseHealthComponent, len(statuses))
+	for name, status := range statuses {
+		c := &ResponseHealthComponent{
+			Status:  healthStatusOK,
+			State:   status.State,
+			Message: status.Message,
+			Since:   status.Since,
+		}
+		if !ok(status.State) {
+			c.Status = healthStatusFail
+		}
+		ret[name] = c
+	}
+
+	if c, ok := ret[componentDiscovery]; ok {
+		h.targetsMutex.RLock()
+		lastDiscovery := h.lastDiscovery
+		h.targetsMutex.RUnlock()
+		if !lastDiscovery.IsZero() {
+			c.LastRefresh = &lastDiscovery
+		}
+	}
+	return ret
+}
+
+// liveness returns the state of the components for liveness.
+// It fails only if any component has failed and the process needs restarting.
+func (h *Handler) liveness() *ResponseHealth {
+	return newResponseHealth(h.healthComponents(h.states.Snapshot(), func(s ComponentState) bool {
+		return s != StateFailed
+	}))
+}
+
+// readiness returns the state of the components for readiness.
+// It fails unless all the components are ready. e.g. no discovery has succeeded yet,
+// the last one is older than staleAfter, or shutting down.
+func (h *Handler) readiness(now time.Time) *ResponseHealth {
+	h.checkStaleness(now)
+	return newResponseHealth(h.healthComponents(h.states.Snapshot(), func(s ComponentState) bool {
+		return s == StateReady
+	}))
+}
+
+func newResponseHealth(components map[string]*ResponseHealthComponent) *ResponseHealth {
+	ret := &ResponseHealth{Status: healthStatusOK, Components: components}
+	for _, c := range components {
+		if c.Status != healthStatusOK {
+			ret.Status = healthStatusFail
+		}
 	}
-	fmt.Println("respond ok")
-	w.WriteHeader(http.StatusOK)
+	return ret
+}
+
+func writeResponseHealth(w http.ResponseWriter, health *ResponseHealth) {
+	w.Header().Set("Content-Type", "application/json")
+	if health.Status != healthStatusOK {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	} else {
+		w.WriteHeader(http.StatusOK)
+	}
+	json.NewEncoder(w).Encode(health)
+}
+
+// endpointHealthy serves the endpoint for liveness check.
+func (h *Handler) endpointHealthy(w http.ResponseWriter, r *http.Request) {
+	writeResponseHealth(w, h.liveness())
+}
+
+// endpointReady serves the endpoint for readiness check.
+// `/-/health` is served by this as well.
+func (h *Handler) endpointReady(w http.ResponseWriter, r *http.Request) {
+	writeResponseHealth(w, h.readiness(time.Now()))
 }
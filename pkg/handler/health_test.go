@@ -1,44 +1,77 @@
 package handler
 
 import (
+	"encoding/json"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
-func TestEndpointHealth(t *testing.T) {
-	t.Skip() // skip the test tentatively
+func TestEndpointReady(t *testing.T) {
 	type pattern struct {
-		description     string
-		atomicBoolValue bool
-		wantCode        int
+		description   string
+		ready         bool
+		shuttingDown  bool
+		lastDiscovery time.Duration
+		wantCode      int
+		wantStatus    map[string]string
 	}
 
 	patterns := []*pattern{
 		{
-			description:     "status ok",
-			atomicBoolValue: true,
-			wantCode:        http.StatusOK,
+			description:   "status ok",
+			ready:         true,
+			lastDiscovery: time.Second,
+			wantCode:      http.StatusOK,
+			wantStatus:    map[string]string{"server": healthStatusOK, "discovery": healthStatusOK},
 		},
 		{
-			description:     "status failed",
-			atomicBoolValue: false,
-			wantCode:        http.StatusServiceUnavailable,
+			description: "not discovered yet",
+			wantCode:    http.StatusServiceUnavailable,
+			wantStatus:  map[string]string{"server": healthStatusOK, "discovery": healthStatusFail},
+		},
+		{
+			description:   "stale discovery",
+			ready:         true,
+			lastDiscovery: 2 * time.Minute,
+			wantCode:      http.StatusServiceUnavailable,
+			wantStatus:    map[string]string{"server": healthStatusOK, "discovery": healthStatusFail},
+		},
+		{
+			description:   "shutting down",
+			ready:         true,
+			shuttingDown:  true,
+			lastDiscovery: time.Second,
+			wantCode:      http.StatusServiceUnavailable,
+			wantStatus:    map[string]string{"server": healthStatusFail, "discovery": healthStatusOK},
 		},
 	}
 
 	for _, p := range patterns {
 		t.Run(p.description, func(t *testing.T) {
-			handler, err := createHandlerByParams(&HandlerParams{DiscovererParams: &DiscovererParams{}})
+			handler, err := createHandlerByParams(&HandlerParams{
+				DiscovererParams: &DiscovererParams{
+					RefreshInterval: 30 * time.Second,
+					StaleFactor:     3,
+				},
+			})
 			if err != nil {
 				t.Fatalf("failed to create handler. err: %s", err)
 			}
-			b := &handler.isReady
-			b.Store(p.atomicBoolValue)
+			if p.ready {
+				handler.states.Set(componentDiscovery, StateReady, "")
+			}
+			if p.shuttingDown {
+				handler.SetShuttingDown()
+			}
+			if p.lastDiscovery > 0 {
+				handler.lastDiscovery = time.Now().Add(-p.lastDiscovery)
+			}
 
-			r := httptest.NewRequest(http.MethodGet, "/-/health", nil)
+			r := httptest.NewRequest(http.MethodGet, "/-/ready", nil)
 			w := httptest.NewRecorder()
-			handler.endpointServiceDiscovery(w, r)
+			handler.endpointReady(w, r)
 			res := w.Result()
 			defer res.Body.Close()
 			got := res.StatusCode
@@ -46,6 +79,38 @@ func TestEndpointHealth(t *testing.T) {
 			if got != want {
 				t.Errorf("unexpected status code of the response. got: %d, want: %d", got, want)
 			}
+
+			var body ResponseHealth
+			err = json.NewDecoder(res.Body).Decode(&body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for name, want := range p.wantStatus {
+				c, ok := body.Components[name]
+				if !ok {
+					t.Errorf("missing component `%s`", name)
+					continue
+				}
+				if c.Status != want {
+					t.Errorf("unexpected status of component `%s`. got: %s, want: %s", name, c.Status, want)
+				}
+			}
 		})
 	}
 }
+
+func TestEndpointHealthy(t *testing.T) {
+	handler, err := createHandlerByParams(&HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatalf("failed to create handler. err: %s", err)
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/-/healthy", nil)
+	w := httptest.NewRecorder()
+	handler.endpointHealthy(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code of the response. got: %d, want: %d", res.StatusCode, http.StatusOK)
+	}
+}
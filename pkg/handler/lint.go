@@ -0,0 +1,136 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// DockerLabelPrefix is the prefix of Docker labels to configure prommux.
+const DockerLabelPrefix = "prommux."
+
+var (
+	// lintTemplateParams is the example to check whether override templates can be executed.
+	lintTemplateParams = &OverrideLabelsTemplateParams{
+		OriginalHost:        "172.17.0.2",
+		OriginalPort:        "9100",
+		OriginalMetricsPath: defaultMetricPath,
+	}
+	// overrideLabelValidators validates the rendered value of each override label.
+	overrideLabelValidators = map[string]func(string) error{
+		overrideLabelScheme:     validateOverrideScheme,
+		overrideLabelAddress:    validateOverrideAddress,
+		overrideLabelMetricPath: validateOverrideMetricsPath,
+	}
+)
+
+// LintIssue is a problem found in a Docker label for prommux.
+type LintIssue struct {
+	// Label is the name of Docker label. e.g. `prommux.address`
+	Label   string
+	Message string
+}
+
+func (i *LintIssue) Error() string {
+	return fmt.Sprintf("%s: %s", i.Label, i.Message)
+}
+
+// LintDockerLabels validates the Docker labels starting with `prommux.` and returns the problems found.
+// The other labels are ignored.
+func LintDockerLabels(labels map[string]string) []*LintIssue {
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var ret []*LintIssue
+	for _, k := range keys {
+		name, ok := strings.CutPrefix(k, DockerLabelPrefix)
+		if !ok {
+			continue
+		}
+		validate, ok := overrideLabelValidators[name]
+		if !ok {
+			ret = append(ret, &LintIssue{
+				Label:   k,
+				Message: fmt.Sprintf("unknown label. (candidates: %s%s, %s%s, %s%s)", DockerLabelPrefix, overrideLabelScheme, DockerLabelPrefix, overrideLabelAddress, DockerLabelPrefix, overrideLabelMetricPath),
+			})
+			continue
+		}
+		rendered, err := applyOverrideLabelsTemplate(labels[k], lintTemplateParams)
+		if err != nil {
+			ret = append(ret, &LintIssue{Label: k, Message: err.Error()})
+			continue
+		}
+		err = validate(rendered)
+		if err != nil {
+			ret = append(ret, &LintIssue{Label: k, Message: fmt.Sprintf("`%s` (rendered from `%s`) %s", rendered, labels[k], err)})
+		}
+	}
+	return ret
+}
+
+func validateOverrideScheme(s string) error {
+	if s != "http" && s != "https" {
+		return fmt.Errorf("is not a valid scheme. (candidates: http, https)")
+	}
+	return nil
+}
+
+func validateOverrideAddress(s string) error {
+	if strings.Contains(s, "://") {
+		return fmt.Errorf("must not contain scheme. use `%s%s` instead", DockerLabelPrefix, overrideLabelScheme)
+	}
+	if strings.ContainsAny(s, "/?#") {
+		return fmt.Errorf("must not contain path. use `%s%s` instead", DockerLabelPrefix, overrideLabelMetricPath)
+	}
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		// port can be omitted
+		host = s
+		port = ""
+	}
+	if host == "" {
+		return fmt.Errorf("has empty host")
+	}
+	if port != "" {
+		n, err := strconv.Atoi(port)
+		if err != nil || n <= 0 || n > 65535 {
+			return fmt.Errorf("has invalid port `%s`", port)
+		}
+	}
+	return nil
+}
+
+func validateOverrideMetricsPath(s string) error {
+	if !strings.HasPrefix(s, "/") {
+		return fmt.Errorf("must start with `/`")
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return fmt.Errorf("is not a valid path: %w", err)
+	}
+	if u.RawQuery != "" || u.Fragment != "" {
+		return fmt.Errorf("must not contain query or fragment")
+	}
+	return nil
+}
+
+// RenderURLFromDockerLabels renders the URL to scrape for the container
+// with the Docker labels, which is reachable at address. (e.g. `172.17.0.2:9100`)
+func RenderURLFromDockerLabels(labels map[string]string, address string) (*url.URL, error) {
+	ls := model.LabelSet{
+		labelNameAddressLabel: model.LabelValue(address),
+	}
+	for k, v := range labels {
+		ls[model.LabelName(labelNameContainerLabelPrefix+strutil.SanitizeLabelName(k))] = model.LabelValue(v)
+	}
+	return geneateURLFromLabels(ls)
+}
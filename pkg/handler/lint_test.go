@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestLintDockerLabels(t *testing.T) {
+	tests := []struct {
+		name       string
+		labels     map[string]string
+		wantLabels []string
+	}{
+		{
+			name: "valid labels",
+			labels: map[string]string{
+				"prommux.scheme":       "https",
+				"prommux.address":      "{{ .OriginalHost }}:1{{ .OriginalPort }}",
+				"prommux.metrics_path": "{{ .OriginalMetricsPath }}/foo",
+				"com.example.foo":      "not a prommux label",
+			},
+		},
+		{
+			name: "unknown label",
+			labels: map[string]string{
+				"prommux.adress": "example.com",
+			},
+			wantLabels: []string{"prommux.adress"},
+		},
+		{
+			name: "invalid template",
+			labels: map[string]string{
+				"prommux.address": "{{ .OriginalHost",
+			},
+			wantLabels: []string{"prommux.address"},
+		},
+		{
+			name: "unknown template parameter",
+			labels: map[string]string{
+				"prommux.address": "{{ .Host }}:9100",
+			},
+			wantLabels: []string{"prommux.address"},
+		},
+		{
+			name: "invalid values",
+			labels: map[string]string{
+				"prommux.scheme":       "ftp",
+				"prommux.address":      "http://example.com:9100",
+				"prommux.metrics_path": "metrics",
+			},
+			wantLabels: []string{"prommux.address", "prommux.metrics_path", "prommux.scheme"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, issue := range LintDockerLabels(tt.labels) {
+				got = append(got, issue.Label)
+			}
+			if diff := cmp.Diff(got, tt.wantLabels); diff != "" {
+				t.Errorf("unexpected issues. diff(-got, +want): %s", diff)
+			}
+		})
+	}
+}
+
+func TestRenderURLFromDockerLabels(t *testing.T) {
+	u, err := RenderURLFromDockerLabels(
+		map[string]string{
+			"prommux.address":      "{{ .OriginalHost }}:1{{ .OriginalPort }}",
+			"prommux.metrics_path": "/foo",
+		},
+		"web:9100",
+	)
+	if err != nil {
+		t.Fatalf("an error occured unexpectedly. err: %s", err)
+	}
+	want := "http://web:19100/foo"
+	if u.String() != want {
+		t.Errorf("expected %s, got %s", want, u.String())
+	}
+}
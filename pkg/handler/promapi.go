@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// prometheusScrapePool is the name of the scrape pool of the targets in Prometheus-compatible API.
+const prometheusScrapePool = "prommux"
+
+// the health of targets in Prometheus-compatible API.
+var prometheusHealths = map[string]string{
+	targetStatusOK:      "up",
+	targetStatusError:   "down",
+	targetStatusUnknown: "unknown",
+}
+
+// ResponsePrometheus is the envelope of the response of Prometheus-compatible API.
+type ResponsePrometheus struct {
+	Status    string `json:"status"`
+	Data      any    `json:"data,omitempty"`
+	ErrorType string `json:"errorType,omitempty"`
+	Error     string `json:"error,omitempty"`
+}
+
+// ResponsePrometheusTargets is the data of the response of Prometheus-compatible targets API.
+type ResponsePrometheusTargets struct {
+	ActiveTargets       []*ResponsePrometheusActiveTarget  `json:"activeTargets"`
+	DroppedTargets      []*ResponsePrometheusDroppedTarget `json:"droppedTargets"`
+	DroppedTargetCounts map[string]int                     `json:"droppedTargetCounts"`
+}
+
+// ResponsePrometheusActiveTarget is a target served by prommux in Prometheus-compatible targets API.
+type ResponsePrometheusActiveTarget struct {
+	// DiscoveredLabels are the labels of the first container retrieved by Docker service discovery.
+	DiscoveredLabels model.LabelSet `json:"discoveredLabels"`
+	// Labels are the labels of the target in the response of service discovery except the reserved ones,
+	// with `job` and `instance` of the upstream.
+	Labels             model.LabelSet `json:"labels"`
+	ScrapePool         string         `json:"scrapePool"`
+	ScrapeURL          string         `json:"scrapeUrl"`
+	GlobalURL          string         `json:"globalUrl"`
+	LastError          string         `json:"lastError"`
+	LastScrape         time.Time      `json:"lastScrape"`
+	LastScrapeDuration float64        `json:"lastScrapeDuration"`
+	Health             string         `json:"health"`
+	ScrapeTimeout      string         `json:"scrapeTimeout,omitempty"`
+}
+
+// ResponsePrometheusDroppedTarget is a target not served by prommux in Prometheus-compatible targets API.
+type ResponsePrometheusDroppedTarget struct {
+	DiscoveredLabels model.LabelSet `json:"discoveredLabels"`
+}
+
+func writeResponsePrometheus(w http.ResponseWriter, code int, res *ResponsePrometheus) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(res)
+}
+
+// newPrometheusActiveTarget converts the target of targets API into the one of Prometheus-compatible API.
+func (h *Handler) newPrometheusActiveTarget(t *ResponseTarget) *ResponsePrometheusActiveTarget {
+	ret := &ResponsePrometheusActiveTarget{
+		DiscoveredLabels: model.LabelSet{},
+		Labels:           model.LabelSet{},
+		ScrapePool:       prometheusScrapePool,
+		ScrapeURL:        t.URL,
+		GlobalURL:        t.URL,
+		Health:           prometheusHealths[t.Status],
+	}
+	if len(t.Containers) > 0 {
+		ret.DiscoveredLabels = t.Containers[0].DiscoveredLabels
+	}
+	for name, value := range t.Labels {
+		if !strings.HasPrefix(string(name), model.ReservedLabelPrefix) {
+			ret.Labels[name] = value
+		}
+	}
+	ret.Labels[model.JobLabel] = prometheusScrapePool
+	if u, err := url.Parse(t.URL); err == nil {
+		ret.Labels[model.InstanceLabel] = model.LabelValue(u.Host)
+	}
+	if s := t.LastScrape; s != nil {
+		ret.LastError = s.Error
+		if s.Error == "" && t.Status == targetStatusError {
+			ret.LastError = fmt.Sprintf("server returned HTTP status %d %s", s.StatusCode, http.StatusText(s.StatusCode))
+		}
+		ret.LastScrape = s.Time
+		ret.LastScrapeDuration = s.Duration.Seconds()
+	}
+	if h.proxyTimeout > 0 {
+		ret.ScrapeTimeout = model.Duration(h.proxyTimeout).String()
+	}
+	return ret
+}
+
+// endpointPrometheusTargets serves the targets API compatible with Prometheus's `/api/v1/targets`.
+// The quarantined targets are reported as dropped ones.
+// It supports the query parameters `state` (active, dropped or any) and `scrapePool` as well as Prometheus.
+func (h *Handler) endpointPrometheusTargets(w http.ResponseWriter, r *http.Request) {
+	state := strings.ToLower(r.URL.Query().Get("state"))
+	showActive := state == "" || state == "any" || state == "active"
+	showDropped := state == "" || state == "any" || state == "dropped"
+	if !showActive && !showDropped {
+		writeResponsePrometheus(w, http.StatusBadRequest, &ResponsePrometheus{
+			Status:    "error",
+			ErrorType: "bad_data",
+			Error:     fmt.Sprintf("invalid state `%s`", state),
+		})
+		return
+	}
+
+	data := &ResponsePrometheusTargets{
+		ActiveTargets:       []*ResponsePrometheusActiveTarget{},
+		DroppedTargets:      []*ResponsePrometheusDroppedTarget{},
+		DroppedTargetCounts: map[string]int{prometheusScrapePool: 0},
+	}
+	if pool := r.URL.Query().Get("scrapePool"); pool != "" && pool != prometheusScrapePool {
+		writeResponsePrometheus(w, http.StatusOK, &ResponsePrometheus{Status: "success", Data: data})
+		return
+	}
+	for _, t := range h.apiTargets(tenantFromContext(r.Context())) {
+		if t.Status == targetStatusQuarantined {
+			data.DroppedTargetCounts[prometheusScrapePool]++
+			if showDropped {
+				data.DroppedTargets = append(data.DroppedTargets, &ResponsePrometheusDroppedTarget{
+					DiscoveredLabels: t.Containers[0].DiscoveredLabels,
+				})
+			}
+			continue
+		}
+		if showActive {
+			data.ActiveTargets = append(data.ActiveTargets, h.newPrometheusActiveTarget(t))
+		}
+	}
+	writeResponsePrometheus(w, http.StatusOK, &ResponsePrometheus{Status: "success", Data: data})
+}
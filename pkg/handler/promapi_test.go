@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestEndpointPrometheusTargets(t *testing.T) {
+	ctx := t.Context()
+
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer exporter.Close()
+	exporterURL, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := model.LabelSet{
+		labelNameAddressLabel:  model.LabelValue(exporterURL.Host),
+		labelNameContainerName: "/exporter",
+	}
+	broken := model.LabelSet{
+		labelNameAddressLabel:             "broken.example.com",
+		labelNameContainerName:            "/broken",
+		labelNameOverrideMetricsPathLabel: "{{ .Broken",
+	}
+	tg := []*targetgroup.Group{{Targets: []model.LabelSet{labels, broken}}}
+	handler, err := createTestHandler(t, tg, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		AdditionalLabels: `{"env":"test"}`,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrapeURL := "http://" + exporterURL.Host + defaultMetricPath
+	hash := endpointHash(scrapeURL)
+	r := httptest.NewRequest(http.MethodGet, "/proxy/"+hash, nil)
+	r = mux.SetURLVars(r, map[string]string{"source": hash})
+	handler.endpointProxy(httptest.NewRecorder(), r)
+
+	request := func(query string) (int, *ResponsePrometheusTargets) {
+		r := httptest.NewRequest(http.MethodGet, "/prometheus/api/v1/targets?"+query, nil)
+		w := httptest.NewRecorder()
+		handler.endpointPrometheusTargets(w, r)
+		res := w.Result()
+		defer res.Body.Close()
+		var body struct {
+			Status string                     `json:"status"`
+			Data   *ResponsePrometheusTargets `json:"data"`
+		}
+		err := json.NewDecoder(res.Body).Decode(&body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.StatusCode, body.Data
+	}
+
+	code, data := request("")
+	if code != http.StatusOK {
+		t.Fatalf("unexpected status code. got: %d, want: %d", code, http.StatusOK)
+	}
+	if len(data.ActiveTargets) != 1 {
+		t.Fatalf("unexpected number of active targets. got: %d, want: 1", len(data.ActiveTargets))
+	}
+	active := data.ActiveTargets[0]
+	if active.ScrapeURL != scrapeURL {
+		t.Errorf("unexpected scrapeUrl. got: %s, want: %s", active.ScrapeURL, scrapeURL)
+	}
+	if active.Health != "down" || active.LastError == "" || active.LastScrape.IsZero() {
+		t.Errorf("unexpected result of the last scrape: %+v", active)
+	}
+	if active.DiscoveredLabels[labelNameContainerName] != "/exporter" {
+		t.Errorf("unexpected discoveredLabels: %s", active.DiscoveredLabels)
+	}
+	wantLabels := model.LabelSet{
+		model.JobLabel:                   prometheusScrapePool,
+		model.InstanceLabel:              model.LabelValue(exporterURL.Host),
+		"env":                            "test",
+		labelNameLabelPrommuxDetectedURL: model.LabelValue(scrapeURL),
+	}
+	if !active.Labels.Equal(wantLabels) {
+		t.Errorf("unexpected labels. got: %s, want: %s", active.Labels, wantLabels)
+	}
+	if len(data.DroppedTargets) != 1 || data.DroppedTargets[0].DiscoveredLabels[labelNameContainerName] != "/broken" {
+		t.Errorf("unexpected dropped targets: %+v", data.DroppedTargets)
+	}
+
+	_, data = request("state=active")
+	if len(data.ActiveTargets) != 1 || len(data.DroppedTargets) != 0 {
+		t.Errorf("unexpected targets for state=active: %+v", data)
+	}
+	_, data = request("scrapePool=other")
+	if len(data.ActiveTargets) != 0 || len(data.DroppedTargets) != 0 {
+		t.Errorf("unexpected targets for the other scrape pool: %+v", data)
+	}
+	code, _ = request("state=foo")
+	if code != http.StatusBadRequest {
+		t.Errorf("unexpected status code for invalid state. got: %d, want: %d", code, http.StatusBadRequest)
+	}
+}
@@ -1,24 +1,56 @@
 package handler
 
 import (
+	"context"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"time"
 
 	"github.com/gorilla/mux"
 )
 
-func createProxy(target url.URL) *httputil.ReverseProxy {
+// newProxyFunc returns the function to create the reverse proxy with transport.
+// http.DefaultTransport is used if transport is nil.
+func newProxyFunc(transport http.RoundTripper) func(*url.URL) *httputil.ReverseProxy {
+	return func(target *url.URL) *httputil.ReverseProxy {
+		rp := createProxy(target)
+		rp.Transport = transport
+		return rp
+	}
+}
+
+// createProxy creates the reverse proxy to the target.
+// It is shared by concurrent requests, so the target must not be modified.
+func createProxy(target *url.URL) *httputil.ReverseProxy {
 	return &httputil.ReverseProxy{
 		Director: func(r *http.Request) {
-			r.URL = &target
+			u := *target
+			r.URL = &u
+			r.Host = u.Host
+			// the token of the tenant must not leak to the exporters, which may belong to someone else.
+			r.Header.Del("Authorization")
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			if rec, ok := w.(*statusRecorder); ok {
+				rec.err = err
+			}
+			// the status code agrees with the reason of the error in the metrics
+			if proxyErrorReason(err) == proxyErrorTimeout {
+				w.WriteHeader(http.StatusGatewayTimeout)
+				return
+			}
+			w.WriteHeader(http.StatusBadGateway)
 		},
 	}
 }
 
+// statusRecorder records the status code, the size of body and the error of the response.
 type statusRecorder struct {
 	http.ResponseWriter
 	status int
+	size   int64
+	err    error
 }
 
 func (rec *statusRecorder) WriteHeader(code int) {
@@ -26,35 +58,47 @@ func (rec *statusRecorder) WriteHeader(code int) {
 	rec.ResponseWriter.WriteHeader(code)
 }
 
+func (rec *statusRecorder) Write(b []byte) (int, error) {
+	n, err := rec.ResponseWriter.Write(b)
+	rec.size += int64(n)
+	return n, err
+}
+
+// Unwrap returns the original ResponseWriter for http.ResponseController.
+func (rec *statusRecorder) Unwrap() http.ResponseWriter {
+	return rec.ResponseWriter
+}
+
 // endpointServiceDiscovery serves reverseproxy for the exporters detected by Docker API.
 func (h *Handler) endpointProxy(w http.ResponseWriter, r *http.Request) {
 	vars := mux.Vars(r)
 	source := vars["source"]
-	h.targetsMutex.RLock()
-	defer h.targetsMutex.RUnlock()
 
-	u, ok := h.proxies[source]
-	if !ok {
+	pt, ok := h.registry.lookup(source)
+	// the targets of the other tenants are hidden as if they do not exist
+	if !ok || !tenantFromContext(r.Context()).allows(source) {
 		http.Error(w, "missing source", http.StatusNotFound)
 		return
 	}
 
-	if h.reverseProxyMap == nil {
-		h.reverseProxyMap = make(map[url.URL]*httputil.ReverseProxy, 1)
-	}
-	rp, ok := h.reverseProxyMap[*u]
-	if !ok {
-		rp = createProxy(*u)
-		h.reverseProxyMap[*u] = rp
+	if h.proxyTimeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), h.proxyTimeout)
+		defer cancel()
+		r = r.WithContext(ctx)
 	}
 
-	rec := statusRecorder{w, 200}
-	rp.ServeHTTP(w, r)
-
-	// record metrics
-	if rec.status == http.StatusOK {
-		proxySuccessCountMetrics.Inc()
-	} else {
-		proxyFailureCountMetrics.Inc()
+	rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+	start := time.Now()
+	pt.proxy.ServeHTTP(rec, r)
+	result := &ScrapeResult{
+		Time:       start,
+		StatusCode: rec.status,
+		Duration:   time.Since(start),
+		Size:       rec.size,
+	}
+	if rec.err != nil {
+		result.Error = rec.err.Error()
 	}
+	pt.stats.lastScrape.Store(result)
+	pt.stats.metrics.observe(result, rec.err)
 }
@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// churnDiscoverer keeps sending the target groups in turn at a short interval until ctx is done.
+type churnDiscoverer struct {
+	tgs [][]*targetgroup.Group
+}
+
+func (d *churnDiscoverer) Run(ctx context.Context, ch chan<- []*targetgroup.Group) error {
+	// the interval keeps the updates frequent without starving the requests
+	ticker := time.NewTicker(100 * time.Microsecond)
+	defer ticker.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return nil
+		}
+		select {
+		case ch <- d.tgs[i%len(d.tgs)]:
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// TestEndpointProxyConcurrency scrapes via the reverse proxy concurrently while the targets keep changing.
+// It is meant to be run with the race detector.
+func TestEndpointProxyConcurrency(t *testing.T) {
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, "up 1\n")
+	}))
+	defer exporter.Close()
+	exporterURL, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stable := model.LabelSet{labelNameAddressLabel: model.LabelValue(exporterURL.Host)}
+	churning := model.LabelSet{
+		labelNameAddressLabel:             model.LabelValue(exporterURL.Host),
+		labelNameOverrideMetricsPathLabel: "{{ .OriginalMetricsPath }}/churning",
+	}
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{
+			IncludeDockerLabels: true,
+			RegexpDockerLabels:  ".*",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	h.sources = []TargetSource{&churnDiscoverer{
+		tgs: [][]*targetgroup.Group{
+			{{Targets: []model.LabelSet{stable}}},
+			{{Targets: []model.LabelSet{stable, churning}}},
+		},
+	}}
+	go func() {
+		h.Run(ctx)
+	}()
+	err = h.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := httptest.NewServer(h.NewRouter())
+	defer server.Close()
+
+	stableHash := endpointHash("http://" + exporterURL.Host + defaultMetricPath)
+	paths := []string{"/proxy/" + stableHash, "/discover", "/status"}
+
+	const workers, requests = 8, 50
+	var wg sync.WaitGroup
+	errCh := make(chan error, workers*requests)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < requests; j++ {
+				path := paths[(i+j)%len(paths)]
+				resp, err := server.Client().Get(server.URL + path)
+				if err != nil {
+					errCh <- err
+					continue
+				}
+				io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+				if resp.StatusCode != http.StatusOK {
+					errCh <- &unexpectedStatusError{path: path, code: resp.StatusCode}
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	close(errCh)
+	for err := range errCh {
+		t.Error(err)
+	}
+
+	pt, ok := h.registry.lookup(stableHash)
+	if !ok {
+		t.Fatal("the stable target is missing")
+	}
+	if last := pt.stats.lastScrape.Load(); last == nil || last.StatusCode != http.StatusOK {
+		t.Errorf("unexpected last scrape of the stable target: %+v", last)
+	}
+}
+
+type unexpectedStatusError struct {
+	path string
+	code int
+}
+
+func (e *unexpectedStatusError) Error() string {
+	return "unexpected status code for " + e.path + ": " + http.StatusText(e.code)
+}
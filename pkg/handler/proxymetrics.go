@@ -0,0 +1,150 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sync"
+	"syscall"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// overflowTargetLabel is the value of `hash` and `container` labels
+// for the targets exceeding the maximum number of targets to have their own series.
+const overflowTargetLabel = "_other"
+
+// defaultProxyMetricsMaxTargets is the maximum number of targets to have their own series
+// when HandlerParams.ProxyMetricsMaxTargets is zero.
+const defaultProxyMetricsMaxTargets = 500
+
+// the reasons of errors to request the upstream exporters.
+const (
+	proxyErrorTimeout           = "timeout"
+	proxyErrorCanceled          = "canceled"
+	proxyErrorConnectionRefused = "connection_refused"
+	proxyErrorDNS               = "dns"
+	proxyErrorOther             = "other"
+)
+
+// proxyMetrics assigns the per-target metrics to targets.
+// The cardinality is bounded by maxTargets, and by deleting the series of the removed targets.
+// The per-target metrics are disabled if maxTargets is negative.
+type proxyMetrics struct {
+	metrics    *metrics
+	maxTargets int
+
+	mu      sync.Mutex
+	tracked map[string]*targetMetrics
+}
+
+func newProxyMetrics(m *metrics, maxTargets int) *proxyMetrics {
+	if maxTargets == 0 {
+		maxTargets = defaultProxyMetricsMaxTargets
+	}
+	return &proxyMetrics{
+		metrics:    m,
+		maxTargets: maxTargets,
+		tracked:    make(map[string]*targetMetrics),
+	}
+}
+
+// targetMetrics is the metrics of a target.
+type targetMetrics struct {
+	requests, errors *prometheus.CounterVec
+	duration, size   prometheus.Observer
+
+	// mu is held for writing while the series are deleted,
+	// so that the observations in flight do not recreate them.
+	mu sync.RWMutex
+	// released is true once the series are deleted, not to recreate them.
+	released bool
+}
+
+func (m *proxyMetrics) newTargetMetrics(hash, container string) *targetMetrics {
+	labels := prometheus.Labels{"hash": hash, "container": container}
+	return &targetMetrics{
+		requests: m.metrics.proxyTargetRequests.MustCurryWith(labels),
+		errors:   m.metrics.proxyTargetErrors.MustCurryWith(labels),
+		duration: m.metrics.proxyTargetDuration.With(labels),
+		size:     m.metrics.proxyTargetResponseSize.With(labels),
+	}
+}
+
+// acquire returns the metrics for the target.
+// The targets beyond maxTargets share the series labeled with overflowTargetLabel.
+// It returns nil if the per-target metrics are disabled, which observes nothing.
+func (m *proxyMetrics) acquire(hash, container string) *targetMetrics {
+	if m.maxTargets < 0 {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if tm, ok := m.tracked[hash]; ok {
+		return tm
+	}
+	if len(m.tracked) >= m.maxTargets {
+		return m.newTargetMetrics(overflowTargetLabel, overflowTargetLabel)
+	}
+	tm := m.newTargetMetrics(hash, container)
+	m.tracked[hash] = tm
+	return tm
+}
+
+// release deletes the series of the target.
+func (m *proxyMetrics) release(hash string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	tm, ok := m.tracked[hash]
+	if !ok {
+		return
+	}
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+	tm.released = true
+	delete(m.tracked, hash)
+	labels := prometheus.Labels{"hash": hash}
+	m.metrics.proxyTargetRequests.DeletePartialMatch(labels)
+	m.metrics.proxyTargetErrors.DeletePartialMatch(labels)
+	m.metrics.proxyTargetDuration.DeletePartialMatch(labels)
+	m.metrics.proxyTargetResponseSize.DeletePartialMatch(labels)
+}
+
+// observe records the result of a request to the upstream exporter.
+func (tm *targetMetrics) observe(result *ScrapeResult, err error) {
+	if tm == nil {
+		return
+	}
+	tm.mu.RLock()
+	defer tm.mu.RUnlock()
+	if tm.released {
+		return
+	}
+	tm.requests.WithLabelValues(fmt.Sprintf("%dxx", result.StatusCode/100)).Inc()
+	tm.duration.Observe(result.Duration.Seconds())
+	tm.size.Observe(float64(result.Size))
+	if err != nil {
+		tm.errors.WithLabelValues(proxyErrorReason(err)).Inc()
+	}
+}
+
+// proxyErrorReason classifies the error to request the upstream exporter into a bounded set of reasons.
+func proxyErrorReason(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	switch {
+	case errors.Is(err, context.DeadlineExceeded):
+		return proxyErrorTimeout
+	case errors.Is(err, context.Canceled):
+		return proxyErrorCanceled
+	case errors.As(err, &dnsErr):
+		return proxyErrorDNS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return proxyErrorConnectionRefused
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return proxyErrorTimeout
+	default:
+		return proxyErrorOther
+	}
+}
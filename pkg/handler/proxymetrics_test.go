@@ -0,0 +1,218 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"sync"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// countSeries returns the number of the series of the collector labeled with hash.
+func countSeries(t *testing.T, c prometheus.Collector, hash string) int {
+	t.Helper()
+	ch := make(chan prometheus.Metric)
+	go func() {
+		c.Collect(ch)
+		close(ch)
+	}()
+	count := 0
+	for m := range ch {
+		var pb dto.Metric
+		err := m.Write(&pb)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, l := range pb.GetLabel() {
+			if l.GetName() == "hash" && l.GetValue() == hash {
+				count++
+			}
+		}
+	}
+	return count
+}
+
+func TestProxyErrorReason(t *testing.T) {
+	patterns := []struct {
+		err  error
+		want string
+	}{
+		{err: context.DeadlineExceeded, want: proxyErrorTimeout},
+		{err: fmt.Errorf("wrapped: %w", context.Canceled), want: proxyErrorCanceled},
+		{err: &net.DNSError{Err: "no such host", Name: "example.invalid"}, want: proxyErrorDNS},
+		{
+			err:  &net.OpError{Op: "dial", Err: &os.SyscallError{Syscall: "connect", Err: syscall.ECONNREFUSED}},
+			want: proxyErrorConnectionRefused,
+		},
+		{err: errors.New("unexpected EOF"), want: proxyErrorOther},
+	}
+	for _, p := range patterns {
+		if got := proxyErrorReason(p.err); got != p.want {
+			t.Errorf("unexpected reason for `%s`. got: %s, want: %s", p.err, got, p.want)
+		}
+	}
+}
+
+func TestProxyMetricsCardinality(t *testing.T) {
+	metrics, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newProxyMetrics(metrics, 1)
+	first := m.acquire("metrics-test-first", "first")
+	second := m.acquire("metrics-test-second", "second")
+	result := &ScrapeResult{StatusCode: http.StatusOK, Duration: time.Millisecond, Size: 10}
+	first.observe(result, nil)
+	second.observe(result, nil)
+
+	if got := countSeries(t, metrics.proxyTargetRequests, "metrics-test-first"); got != 1 {
+		t.Errorf("unexpected number of series of the first target. got: %d, want: 1", got)
+	}
+	if got := countSeries(t, metrics.proxyTargetRequests, "metrics-test-second"); got != 0 {
+		t.Errorf("the target beyond the maximum must not have its own series. got: %d", got)
+	}
+
+	m.release("metrics-test-first")
+	first.observe(result, nil)
+	if got := countSeries(t, metrics.proxyTargetRequests, "metrics-test-first"); got != 0 {
+		t.Errorf("the series of the released target must be deleted. got: %d", got)
+	}
+}
+
+func TestProxyMetricsReleaseWhileObserving(t *testing.T) {
+	metrics, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	m := newProxyMetrics(metrics, 10)
+	tm := m.acquire("metrics-test-release", "release")
+	result := &ScrapeResult{StatusCode: http.StatusOK, Duration: time.Millisecond, Size: 10}
+
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+					tm.observe(result, errors.New("failed"))
+				}
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	m.release("metrics-test-release")
+	close(stop)
+	wg.Wait()
+
+	for name, c := range map[string]prometheus.Collector{
+		"requests": metrics.proxyTargetRequests,
+		"errors":   metrics.proxyTargetErrors,
+		"duration": metrics.proxyTargetDuration,
+		"size":     metrics.proxyTargetResponseSize,
+	} {
+		if got := countSeries(t, c, "metrics-test-release"); got != 0 {
+			t.Errorf("the series of %s must not be recreated after release. got: %d", name, got)
+		}
+	}
+}
+
+func TestProxyMetricsMaxTargets(t *testing.T) {
+	metrics, err := newMetrics(prometheus.NewRegistry())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m := newProxyMetrics(metrics, 0); m.maxTargets != defaultProxyMetricsMaxTargets {
+		t.Errorf("unexpected maximum number of targets for zero. got: %d, want: %d", m.maxTargets, defaultProxyMetricsMaxTargets)
+	}
+
+	m := newProxyMetrics(metrics, -1)
+	tm := m.acquire("metrics-test-disabled", "disabled")
+	if tm != nil {
+		t.Fatal("per-target metrics must be disabled for negative")
+	}
+	tm.observe(&ScrapeResult{StatusCode: http.StatusOK}, nil)
+	for _, hash := range []string{"metrics-test-disabled", overflowTargetLabel} {
+		if got := countSeries(t, metrics.proxyTargetRequests, hash); got != 0 {
+			t.Errorf("unexpected series of `%s` while disabled. got: %d", hash, got)
+		}
+	}
+	m.release("metrics-test-disabled")
+}
+
+func TestEndpointProxyTimeout(t *testing.T) {
+	ctx := t.Context()
+
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer exporter.Close()
+	exporterURL, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := model.LabelSet{
+		labelNameAddressLabel:  model.LabelValue(exporterURL.Host),
+		labelNameContainerName: "/slow",
+	}
+	tg := []*targetgroup.Group{{Targets: []model.LabelSet{labels}}}
+	handler, err := createTestHandler(t, tg, &HandlerParams{
+		DiscovererParams:       &DiscovererParams{},
+		ProxyMetricsMaxTargets: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handler.proxyTimeout = 50 * time.Millisecond
+
+	hash := endpointHash("http://" + exporterURL.Host + defaultMetricPath)
+	r := httptest.NewRequest(http.MethodGet, "/proxy/"+hash, nil)
+	r = mux.SetURLVars(r, map[string]string{"source": hash})
+	w := httptest.NewRecorder()
+	handler.endpointProxy(w, r)
+
+	if w.Code != http.StatusGatewayTimeout {
+		t.Errorf("unexpected status code. got: %d, want: %d", w.Code, http.StatusGatewayTimeout)
+	}
+	got := testutil.ToFloat64(handler.metrics.proxyTargetErrors.WithLabelValues(hash, "slow", proxyErrorTimeout))
+	if got != 1 {
+		t.Errorf("unexpected count of timeout errors. got: %f, want: 1", got)
+	}
+	got = testutil.ToFloat64(handler.metrics.proxyTargetRequests.WithLabelValues(hash, "slow", "5xx"))
+	if got != 1 {
+		t.Errorf("unexpected count of 5xx requests. got: %f, want: 1", got)
+	}
+	pt, _ := handler.registry.lookup(hash)
+	if last := pt.stats.lastScrape.Load(); last == nil || last.Error == "" {
+		t.Errorf("the error must be recorded in the last scrape: %+v", last)
+	}
+}
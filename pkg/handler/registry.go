@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http/httputil"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// proxyRegistry holds the targets served by the reverse proxy.
+// The targets are replaced as a whole by copy-on-write,
+// so that lookups on the hot path never take locks nor wait for updates.
+type proxyRegistry struct {
+	targets atomic.Pointer[map[string]*proxyTarget]
+	// updateMu serializes updates.
+	updateMu sync.Mutex
+	// newProxy builds the reverse proxy for a new target.
+	newProxy func(*url.URL) *httputil.ReverseProxy
+	metrics  *proxyMetrics
+}
+
+func newProxyRegistry(newProxy func(*url.URL) *httputil.ReverseProxy, metrics *proxyMetrics) *proxyRegistry {
+	r := &proxyRegistry{newProxy: newProxy, metrics: metrics}
+	targets := make(map[string]*proxyTarget)
+	r.targets.Store(&targets)
+	return r
+}
+
+// lookup returns the target for the hash.
+func (r *proxyRegistry) lookup(hash string) (*proxyTarget, bool) {
+	pt, ok := (*r.targets.Load())[hash]
+	return pt, ok
+}
+
+// all returns all the targets keyed by hash. The returned map must not be modified.
+func (r *proxyRegistry) all() map[string]*proxyTarget {
+	return *r.targets.Load()
+}
+
+// replace replaces all the targets with the new ones discovered at now.
+// The reverse proxies and the runtime data of the targets which already exist are taken over,
+// and the reverse proxies for the new targets are built by newProxy.
+// The metrics of the removed targets are released.
+func (r *proxyRegistry) replace(targets map[string]*proxyTarget, now time.Time) {
+	r.updateMu.Lock()
+	defer r.updateMu.Unlock()
+
+	old := *r.targets.Load()
+	for hash, pt := range targets {
+		if prev, ok := old[hash]; ok {
+			pt.proxy = prev.proxy
+			pt.stats = prev.stats
+		} else {
+			pt.stats = &targetStats{
+				firstSeen: now,
+				metrics:   r.metrics.acquire(hash, pt.containerName()),
+			}
+			pt.proxy = r.newProxy(pt.url)
+		}
+		pt.stats.lastSeen.Store(&now)
+	}
+	for hash := range old {
+		if _, ok := targets[hash]; !ok {
+			r.metrics.release(hash)
+		}
+	}
+	r.targets.Store(&targets)
+}
@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"net/url"
+	"strconv"
+)
+
+// the keys to assign the targets to shards.
+const (
+	// ShardKeyHash shards the targets by their hashes, i.e. the URLs of the exporters.
+	ShardKeyHash = "hash"
+	// ShardKeyContainer shards the targets by the names of their containers.
+	ShardKeyContainer = "container"
+	// ShardKeyService shards the targets by their compose services, so that the replicas of a service go to the same shard.
+	ShardKeyService = "service"
+)
+
+// maxShards is the maximum number of shards, which bounds the cost to assign the entries to shards.
+const maxShards = 1024
+
+// shard is the subset of the targets requested by `shard` and `shards` query parameters.
+// The zero value is all the targets.
+type shard struct {
+	index, count uint64
+}
+
+// parseShard parses `shard` and `shards` query parameters.
+func parseShard(query url.Values) (shard, error) {
+	s, n := query.Get("shard"), query.Get("shards")
+	if s == "" && n == "" {
+		return shard{}, nil
+	}
+	if s == "" || n == "" {
+		return shard{}, errors.New("both shard and shards must be specified")
+	}
+	count, err := strconv.ParseUint(n, 10, 64)
+	if err != nil || count == 0 || count > maxShards {
+		return shard{}, fmt.Errorf("shards must be an integer in [1, %d]: `%s`", maxShards, n)
+	}
+	index, err := strconv.ParseUint(s, 10, 64)
+	if err != nil || index >= count {
+		return shard{}, fmt.Errorf("shard must be an integer in [0, %d): `%s`", count, s)
+	}
+	return shard{index: index, count: count}, nil
+}
+
+// shardOf returns the shard of key out of count shards.
+// The shard is chosen by rendezvous hashing, so the other keys stay in their shards
+// when keys are added or removed, and only 1/count of them move when a shard is added.
+func shardOf(key string, count uint64) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	k := h.Sum64()
+	var best, bestScore uint64
+	for i := range count {
+		// the scores of the shards are the sequence of SplitMix64 seeded with the key,
+		// which are independent of each other unlike the ones of FNV over the similar inputs
+		if score := splitmix64(k + (i+1)*splitmix64Gamma); i == 0 || score > bestScore {
+			best, bestScore = i, score
+		}
+	}
+	return best
+}
+
+// splitmix64Gamma is the increment of the state of SplitMix64.
+const splitmix64Gamma = 0x9e3779b97f4a7c15
+
+// splitmix64 is the finalizer of SplitMix64, which mixes every bit of x into every bit of the result.
+func splitmix64(x uint64) uint64 {
+	x = (x ^ (x >> 30)) * 0xbf58476d1ce4e5b9
+	x = (x ^ (x >> 27)) * 0x94d049bb133111eb
+	return x ^ (x >> 31)
+}
+
+// String returns the key of the shard to memoize the rendered bodies.
+func (s shard) String() string {
+	if s.count <= 1 {
+		return ""
+	}
+	return fmt.Sprintf("%d/%d", s.index, s.count)
+}
+
+// validateShardKey returns an error if key is not one of the keys to shard the targets.
+func validateShardKey(key string) error {
+	switch key {
+	case ShardKeyHash, ShardKeyContainer, ShardKeyService:
+		return nil
+	default:
+		return fmt.Errorf("unknown shard key `%s`. (candidates: hash, container, service)", key)
+	}
+}
+
+// shardKeyOf returns the key of the target to assign it to a shard.
+// It falls back to the hash if the target does not have the key.
+func (h *Handler) shardKeyOf(hash string, pt *proxyTarget) string {
+	var v string
+	switch h.shardKey {
+	case ShardKeyContainer:
+		v = string(pt.labels[0][labelNameContainerName])
+	case ShardKeyService:
+		if project := pt.labels[0][labelNameComposeProject]; project != "" {
+			if service := pt.labels[0][labelNameComposeService]; service != "" {
+				v = string(project) + "/" + string(service)
+			}
+		}
+	}
+	if v == "" {
+		return hash
+	}
+	return v
+}
@@ -0,0 +1,219 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// shardTargets returns n targets, each of which is the replica `i % replicas` of the compose service `i / replicas`.
+func shardTargets(n, replicas int) []model.LabelSet {
+	ret := make([]model.LabelSet, 0, n)
+	for i := range n {
+		ret = append(ret, model.LabelSet{
+			labelNameAddressLabel:   model.LabelValue(fmt.Sprintf("exporter-%d:9100", i)),
+			labelNameContainerName:  model.LabelValue(fmt.Sprintf("/exporter-%d", i)),
+			labelNameComposeProject: "monitoring",
+			labelNameComposeService: model.LabelValue(fmt.Sprintf("service-%d", i/replicas)),
+		})
+	}
+	return ret
+}
+
+// discoverShard requests the shard to h and returns the paths of the targets.
+func discoverShard(t *testing.T, h *Handler, query string) (map[string]bool, int) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/discover?"+query, nil)
+	w := httptest.NewRecorder()
+	h.endpointServiceDiscovery(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, res.StatusCode
+	}
+	var configs []*StaticConfig
+	err := json.NewDecoder(res.Body).Decode(&configs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret := make(map[string]bool, len(configs))
+	for _, c := range configs {
+		ret[string(c.Labels[labelNameMetricsPathLabel])] = true
+	}
+	return ret, res.StatusCode
+}
+
+func TestEndpointServiceDiscoveryShard(t *testing.T) {
+	const shards = 3
+	h, err := createTestHandler(t, nil, &HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	targets := shardTargets(30, 1)
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{Targets: targets}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the shards are disjoint and cover all the targets
+	assigned := make(map[string]int)
+	for i := range shards {
+		got, code := discoverShard(t, h, fmt.Sprintf("shard=%d&shards=%d", i, shards))
+		if code != http.StatusOK {
+			t.Fatalf("unexpected status code: %d", code)
+		}
+		if len(got) == 0 {
+			t.Errorf("shard %d is empty", i)
+		}
+		for path := range got {
+			if prev, ok := assigned[path]; ok {
+				t.Errorf("%s is assigned to both shard %d and %d", path, prev, i)
+			}
+			assigned[path] = i
+		}
+	}
+	if len(assigned) != len(targets) {
+		t.Errorf("unexpected number of assigned targets. got: %d, want: %d", len(assigned), len(targets))
+	}
+
+	// the rest stay in their shards when targets are removed
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{Targets: targets[10:]}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range shards {
+		got, _ := discoverShard(t, h, fmt.Sprintf("shard=%d&shards=%d", i, shards))
+		for path := range got {
+			if assigned[path] != i {
+				t.Errorf("%s moved from shard %d to %d", path, assigned[path], i)
+			}
+		}
+	}
+
+	all, _ := discoverShard(t, h, "")
+	if len(all) != 20 {
+		t.Errorf("unexpected number of targets without shard. got: %d, want: 20", len(all))
+	}
+}
+
+func TestEndpointServiceDiscoveryShardByService(t *testing.T) {
+	const shards = 4
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		ShardKey:         ShardKeyService,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{Targets: shardTargets(40, 4)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	serviceOf := make(map[string]string)
+	for _, e := range h.sdSnapshot.Load().entries {
+		serviceOf["/proxy/"+e.hash] = e.shardKey
+	}
+	shardOf := make(map[string]int)
+	for i := range shards {
+		got, _ := discoverShard(t, h, fmt.Sprintf("shard=%d&shards=%d", i, shards))
+		for path := range got {
+			service := serviceOf[path]
+			if prev, ok := shardOf[service]; ok && prev != i {
+				t.Errorf("the replicas of %s are split into shard %d and %d", service, prev, i)
+			}
+			shardOf[service] = i
+		}
+	}
+	if len(shardOf) != 10 {
+		t.Errorf("unexpected number of services. got: %d, want: 10", len(shardOf))
+	}
+}
+
+func TestEndpointServiceDiscoveryShardInvalid(t *testing.T) {
+	h, err := createTestHandler(t, nil, &HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, query := range []string{"shard=0", "shards=2", "shard=2&shards=2", "shard=0&shards=0", "shard=-1&shards=2", "shard=a&shards=2", "shard=0&shards=1025"} {
+		if _, code := discoverShard(t, h, query); code != http.StatusBadRequest {
+			t.Errorf("unexpected status code for `%s`. got: %d, want: %d", query, code, http.StatusBadRequest)
+		}
+	}
+
+	if _, code := discoverShard(t, h, fmt.Sprintf("shard=%d&shards=%d", maxShards-1, maxShards)); code != http.StatusOK {
+		t.Errorf("unexpected status code for the maximum number of shards. got: %d, want: %d", code, http.StatusOK)
+	}
+
+	_, err = createTestHandler(t, nil, &HandlerParams{ShardKey: "image"})
+	if err == nil {
+		t.Error("unknown shard key must be rejected")
+	}
+}
+
+func TestShardOfBalance(t *testing.T) {
+	const keys = 10000
+	for count := uint64(2); count <= 8; count++ {
+		sizes := make([]int, count)
+		for i := range keys {
+			sizes[shardOf(fmt.Sprintf("/exporter-%d", i), count)]++
+		}
+		want := float64(keys) / float64(count)
+		for i, size := range sizes {
+			if math.Abs(float64(size)-want) > want*0.1 {
+				t.Errorf("shard %d/%d is unbalanced. got: %d, want: %.0f ± 10%%", i, count, size, want)
+			}
+		}
+	}
+}
+
+func TestShardOfMovement(t *testing.T) {
+	const keys = 10000
+	for count := uint64(1); count <= 8; count++ {
+		moved := 0
+		for i := range keys {
+			key := fmt.Sprintf("/exporter-%d", i)
+			before, after := shardOf(key, count), shardOf(key, count+1)
+			if before == after {
+				continue
+			}
+			moved++
+			if after != count {
+				t.Errorf("%s moved from shard %d to %d, not to the added shard %d", key, before, after, count)
+			}
+		}
+		got, want := float64(moved)/keys, 1/float64(count+1)
+		if math.Abs(got-want) > 0.02 {
+			t.Errorf("unexpected ratio of the moved keys from %d to %d shards. got: %.3f, want: %.3f", count, count+1, got, want)
+		}
+	}
+}
+
+func TestShardAssignmentMemoized(t *testing.T) {
+	entries := make([]*sdEntry, 0, 10)
+	for i := range 10 {
+		entries = append(entries, &sdEntry{hash: fmt.Sprint(i), shardKey: fmt.Sprint(i)})
+	}
+	s, err := newSDSnapshot(entries, nil, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	first := s.shardAssignment(3)
+	if second := s.shardAssignment(3); &first[0] != &second[0] {
+		t.Error("the assignment must be memoized per number of shards")
+	}
+	for count := uint64(4); count < 4+maxShardAssignments; count++ {
+		s.shardAssignment(count)
+	}
+	if _, ok := s.shards.Load(uint64(3 + maxShardAssignments)); ok {
+		t.Errorf("the number of memoized assignments must be bounded by %d", maxShardAssignments)
+	}
+}
@@ -0,0 +1,188 @@
+package handler
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// maxSDBodies is the maximum number of the rendered bodies memoized per snapshot, for each of all the targets and shards.
+// The address in the bodies comes from the Host header of requests, so it must be bounded.
+const maxSDBodies = 16
+
+// maxShardAssignments is the maximum number of the assignments of the entries to shards memoized per snapshot.
+const maxShardAssignments = 16
+
+// sdEntry is an entry of service discovery which does not depend on requests.
+type sdEntry struct {
+	hash string
+	// labels is the labels of the entry except the scheme, which depends on requests.
+	labels model.LabelSet
+	// project is the compose project of the container of the entry, if any.
+	project string
+	// shardKey is the key to assign the entry to a shard.
+	shardKey string
+}
+
+// sdSnapshot is the immutable snapshot of the response of service discovery.
+// It is rebuilt only when the targets change, and shared by all the requests.
+type sdSnapshot struct {
+	entries []*sdEntry
+	// version is incremented every time the content of the snapshot changes.
+	version uint64
+	// digest is the hash of entries to detect changes.
+	digest string
+	// modified is the time when the content of the snapshot changed last.
+	modified time.Time
+
+	// bodies memoizes the rendered bodies keyed by scheme, address and shard.
+	// The bodies of shards are counted apart, not to let the requests of arbitrary shards use up the ones of all the targets.
+	bodies           sync.Map
+	bodiesCount      atomic.Int32
+	shardBodiesCount atomic.Int32
+	// shards memoizes the shards of the entries keyed by the number of shards.
+	shards      sync.Map
+	shardsCount atomic.Int32
+}
+
+// sdBody is the rendered response of service discovery for a pair of scheme and address.
+type sdBody struct {
+	raw     []byte
+	gzipped []byte
+	etag    string
+}
+
+func newSDSnapshot(entries []*sdEntry, prev *sdSnapshot, now time.Time) (*sdSnapshot, error) {
+	digest, err := digestSDEntries(entries)
+	if err != nil {
+		return nil, err
+	}
+	// keep the previous one so that ETag, Last-Modified and memoized bodies survive no-op updates
+	if prev != nil && prev.digest == digest {
+		return prev, nil
+	}
+	s := &sdSnapshot{
+		entries:  entries,
+		digest:   digest,
+		modified: now,
+	}
+	if prev != nil {
+		s.version = prev.version + 1
+	}
+	return s, nil
+}
+
+func digestSDEntries(entries []*sdEntry) (string, error) {
+	h := sha256.New()
+	enc := json.NewEncoder(h)
+	for _, e := range entries {
+		// LabelSet is encoded with sorted keys, so the digest is stable
+		err := enc.Encode(e.labels)
+		if err == nil {
+			err = enc.Encode(e.project)
+		}
+		if err == nil {
+			err = enc.Encode(e.shardKey)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to encode labels of `%s`: %w", e.hash, err)
+		}
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// staticConfigs renders the entries for the scheme and address.
+func (s *sdSnapshot) staticConfigs(scheme, address string) []*StaticConfig {
+	return s.shardStaticConfigs(scheme, address, shard{})
+}
+
+// shardStaticConfigs renders the entries in the shard for the scheme and address.
+func (s *sdSnapshot) shardStaticConfigs(scheme, address string, sh shard) []*StaticConfig {
+	var assigned []uint16
+	if sh.count > 1 {
+		assigned = s.shardAssignment(sh.count)
+	}
+	ret := make([]*StaticConfig, 0, len(s.entries))
+	for i, e := range s.entries {
+		if assigned != nil && uint64(assigned[i]) != sh.index {
+			continue
+		}
+		labels := e.labels.Clone()
+		labels[labelNameSchemeLabel] = model.LabelValue(scheme)
+		ret = append(ret, &StaticConfig{
+			Targets: []string{address},
+			Labels:  labels,
+		})
+	}
+	return ret
+}
+
+// shardAssignment returns the shards of the entries out of count shards, in the order of the entries.
+func (s *sdSnapshot) shardAssignment(count uint64) []uint16 {
+	if v, ok := s.shards.Load(count); ok {
+		return v.([]uint16)
+	}
+	ret := make([]uint16, len(s.entries))
+	for i, e := range s.entries {
+		ret[i] = uint16(shardOf(e.shardKey, count))
+	}
+	if s.shardsCount.Add(1) <= maxShardAssignments {
+		v, _ := s.shards.LoadOrStore(count, ret)
+		return v.([]uint16)
+	}
+	return ret
+}
+
+// body returns the rendered response in the shard for the scheme and address.
+// gzipped is prepared only if withGzip is true.
+func (s *sdSnapshot) body(scheme, address string, sh shard, withGzip bool) (*sdBody, error) {
+	key := scheme + "://" + address + "#" + sh.String()
+	if v, ok := s.bodies.Load(key); ok {
+		b := v.(*sdBody)
+		if !withGzip || b.gzipped != nil {
+			return b, nil
+		}
+	}
+
+	var buf bytes.Buffer
+	err := json.NewEncoder(&buf).Encode(s.shardStaticConfigs(scheme, address, sh))
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode static configs: %w", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	b := &sdBody{
+		raw:  buf.Bytes(),
+		etag: `"` + hex.EncodeToString(sum[:16]) + `"`,
+	}
+	if withGzip {
+		var gz bytes.Buffer
+		w := gzip.NewWriter(&gz)
+		_, err = w.Write(b.raw)
+		if err == nil {
+			err = w.Close()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to compress static configs: %w", err)
+		}
+		b.gzipped = gz.Bytes()
+	}
+
+	count := &s.bodiesCount
+	if sh.count > 1 {
+		count = &s.shardBodiesCount
+	}
+	if _, ok := s.bodies.Load(key); ok {
+		s.bodies.Store(key, b)
+	} else if count.Add(1) <= maxSDBodies {
+		s.bodies.Store(key, b)
+	}
+	return b, nil
+}
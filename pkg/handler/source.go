@@ -0,0 +1,154 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// TargetSource discovers the targets to be served by Handler.
+//
+// Run sends the whole set of the target groups to ch every time it changes, until ctx is done.
+// Each set replaces the previous one sent by the same source.
+// Run must return when ctx is done. If it returns before that, it is restarted with exponential backoff.
+//
+// A source may implement fmt.Stringer to be named in logs,
+// and `Unregister()` to unregister its metrics after Handler stops.
+type TargetSource interface {
+	Run(ctx context.Context, ch chan<- []*targetgroup.Group) error
+}
+
+// the backoff to restart a target source after it exits.
+const (
+	minDiscovererBackoff = time.Second
+	maxDiscovererBackoff = time.Minute
+)
+
+// metricsUnregisterer is implemented by the target sources which register metrics.
+type metricsUnregisterer interface {
+	Unregister()
+}
+
+// Option configures Handler created by NewHandler.
+type Option func(*handlerOptions)
+
+type handlerOptions struct {
+	sources    []TargetSource
+	registerer prometheus.Registerer
+	gatherer   prometheus.Gatherer
+	logger     *slog.Logger
+	httpClient *http.Client
+}
+
+// WithTargetSources makes Handler discover the targets from sources instead of Docker API.
+func WithTargetSources(sources ...TargetSource) Option {
+	return func(o *handlerOptions) {
+		o.sources = append(o.sources, sources...)
+	}
+}
+
+// WithRegistry makes Handler register its metrics on reg, and serve the metrics gathered by gatherer on `/metrics`.
+// It overrides Registerer and Gatherer of HandlerParams.
+func WithRegistry(reg prometheus.Registerer, gatherer prometheus.Gatherer) Option {
+	return func(o *handlerOptions) {
+		o.registerer = reg
+		o.gatherer = gatherer
+	}
+}
+
+// WithLogger makes Handler write logs to logger. It overrides Logger of HandlerParams.
+func WithLogger(logger *slog.Logger) Option {
+	return func(o *handlerOptions) {
+		o.logger = logger
+	}
+}
+
+// WithHTTPClient makes the reverse proxy request the exporters with the transport of client.
+// The timeout of client is not used. Use ProxyTimeout of HandlerParams instead.
+func WithHTTPClient(client *http.Client) Option {
+	return func(o *handlerOptions) {
+		o.httpClient = client
+	}
+}
+
+// sourceUpdate is an update from a target source.
+type sourceUpdate struct {
+	index int
+	tgs   []*targetgroup.Group
+	// err is not nil if the source exited and is being restarted.
+	err error
+}
+
+// sourceName returns the name of src for logs.
+func sourceName(src TargetSource) string {
+	if s, ok := src.(fmt.Stringer); ok {
+		return s.String()
+	}
+	return fmt.Sprintf("%T", src)
+}
+
+// runSource runs src and forwards its updates to updates until ctx is done.
+// src is restarted with exponential backoff if it exits, and the error is forwarded as well.
+func (h *Handler) runSource(ctx context.Context, index int, src TargetSource, updates chan<- *sourceUpdate) {
+	name := sourceName(src)
+	backoff := minDiscovererBackoff
+	for {
+		ch := make(chan []*targetgroup.Group)
+		errCh := make(chan error, 1)
+		go func() {
+			errCh <- src.Run(ctx, ch)
+		}()
+
+		var err error
+	receive:
+		for {
+			select {
+			case tgs := <-ch:
+				backoff = minDiscovererBackoff
+				select {
+				case updates <- &sourceUpdate{index: index, tgs: tgs}:
+				case <-ctx.Done():
+				}
+			case err = <-errCh:
+				break receive
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+
+		if err == nil {
+			err = errors.New("exited unexpectedly")
+		}
+		h.logger.ErrorContext(
+			ctx,
+			"target source exited",
+			slog.String("source", name),
+			slog.Any("error", err),
+			slog.String("backoff", backoff.String()),
+		)
+		select {
+		case updates <- &sourceUpdate{
+			index: index,
+			err:   fmt.Errorf("an error occured when executing %s, restarting in %s: %w", name, backoff, err),
+		}:
+		case <-ctx.Done():
+			return
+		}
+
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return
+		}
+		h.logger.InfoContext(ctx, "restarting target source", slog.String("source", name))
+		h.metrics.discovererRestarts.Inc()
+		backoff = min(backoff*2, maxDiscovererBackoff)
+	}
+}
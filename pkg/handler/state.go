@@ -0,0 +1,189 @@
+package handler
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ComponentState is the state of a component of Handler.
+type ComponentState int
+
+const (
+	// StateStarting indicates the component has not been ready yet.
+	StateStarting ComponentState = iota
+	// StateReady indicates the component works fine.
+	StateReady
+	// StateDegraded indicates the component works but should not receive new requests. e.g. stale discovery
+	StateDegraded
+	// StateFailed indicates the component stopped working.
+	StateFailed
+)
+
+const (
+	// componentDiscovery is the component to receive targets from the discoverer.
+	componentDiscovery = "discovery"
+	// componentServer is the component to serve HTTP endpoints.
+	componentServer = "server"
+)
+
+var componentStateNames = map[ComponentState]string{
+	StateStarting: "starting",
+	StateReady:    "ready",
+	StateDegraded: "degraded",
+	StateFailed:   "failed",
+}
+
+func (s ComponentState) String() string {
+	if name, ok := componentStateNames[s]; ok {
+		return name
+	}
+	return fmt.Sprintf("unknown(%d)", int(s))
+}
+
+func (s ComponentState) MarshalText() ([]byte, error) {
+	return []byte(s.String()), nil
+}
+
+func (s *ComponentState) UnmarshalText(text []byte) error {
+	for state, name := range componentStateNames {
+		if name == string(text) {
+			*s = state
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown component state `%s`", text)
+}
+
+// ComponentStatus is the state of a component with its detail.
+type ComponentStatus struct {
+	State   ComponentState `json:"state"`
+	Message string         `json:"message,omitempty"`
+	// Since is the time when the component transitioned to State.
+	Since time.Time `json:"since"`
+}
+
+// ComponentStatuses is the snapshot of the statuses of all the components.
+type ComponentStatuses map[string]ComponentStatus
+
+// Ready reports whether all the components are ready.
+func (s ComponentStatuses) Ready() bool {
+	for _, c := range s {
+		if c.State != StateReady {
+			return false
+		}
+	}
+	return true
+}
+
+// Alive reports whether no component has failed.
+func (s ComponentStatuses) Alive() bool {
+	for _, c := range s {
+		if c.State == StateFailed {
+			return false
+		}
+	}
+	return true
+}
+
+// stateBroadcaster holds the statuses of components and broadcasts their updates to subscribers.
+// Broadcasting never blocks: each subscriber holds at most one pending snapshot,
+// which is replaced by newer one if the subscriber has not received it yet.
+type stateBroadcaster struct {
+	mu          sync.Mutex
+	statuses    ComponentStatuses
+	subscribers map[chan ComponentStatuses]struct{}
+}
+
+func newStateBroadcaster(components ...string) *stateBroadcaster {
+	b := &stateBroadcaster{
+		statuses:    make(ComponentStatuses, len(components)),
+		subscribers: make(map[chan ComponentStatuses]struct{}),
+	}
+	now := time.Now()
+	for _, c := range components {
+		b.statuses[c] = ComponentStatus{State: StateStarting, Since: now}
+	}
+	return b
+}
+
+// snapshot returns the copy of statuses. b.mu must be held.
+func (b *stateBroadcaster) snapshot() ComponentStatuses {
+	ret := make(ComponentStatuses, len(b.statuses))
+	for k, v := range b.statuses {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Set updates the status of the component and notifies subscribers if it changed.
+func (b *stateBroadcaster) Set(component string, state ComponentState, message string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	old, ok := b.statuses[component]
+	if ok && old.State == state && old.Message == message {
+		return
+	}
+	since := old.Since
+	if !ok || old.State != state {
+		since = time.Now()
+	}
+	b.statuses[component] = ComponentStatus{State: state, Message: message, Since: since}
+
+	snapshot := b.snapshot()
+	for ch := range b.subscribers {
+		select {
+		case ch <- snapshot:
+			continue
+		default:
+		}
+		// drop the pending snapshot not received yet
+		select {
+		case <-ch:
+		default:
+		}
+		// only this goroutine sends to ch, so it never blocks
+		select {
+		case ch <- snapshot:
+		default:
+		}
+	}
+}
+
+// Get returns the status of the component.
+func (b *stateBroadcaster) Get(component string) ComponentStatus {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.statuses[component]
+}
+
+// Snapshot returns the statuses of all the components.
+func (b *stateBroadcaster) Snapshot() ComponentStatuses {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.snapshot()
+}
+
+// Subscribe returns the channel to receive the snapshots of statuses on each update,
+// starting with the current one, and the function to unsubscribe.
+// The channel is closed on unsubscription.
+func (b *stateBroadcaster) Subscribe() (<-chan ComponentStatuses, func()) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan ComponentStatuses, 1)
+	ch <- b.snapshot()
+	b.subscribers[ch] = struct{}{}
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			defer b.mu.Unlock()
+			delete(b.subscribers, ch)
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStateBroadcaster(t *testing.T) {
+	b := newStateBroadcaster(componentDiscovery, componentServer)
+
+	ch, unsubscribe := b.Subscribe()
+
+	// the current statuses are received first
+	got := <-ch
+	if state := got[componentDiscovery].State; state != StateStarting {
+		t.Errorf("unexpected initial state. got: %s, want: %s", state, StateStarting)
+	}
+
+	// Set never blocks even if the subscriber does not receive
+	done := make(chan struct{})
+	go func() {
+		b.Set(componentDiscovery, StateReady, "")
+		b.Set(componentDiscovery, StateDegraded, "stale")
+		b.Set(componentServer, StateReady, "")
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Set blocked by the subscriber")
+	}
+
+	// the updates are coalesced into the latest one
+	got = <-ch
+	if status := got[componentDiscovery]; status.State != StateDegraded || status.Message != "stale" {
+		t.Errorf("unexpected status of discovery. got: %+v", status)
+	}
+	if state := got[componentServer].State; state != StateReady {
+		t.Errorf("unexpected state of server. got: %s, want: %s", state, StateReady)
+	}
+	select {
+	case v := <-ch:
+		t.Errorf("received an unexpected update: %+v", v)
+	default:
+	}
+
+	// the same status is not notified
+	b.Set(componentServer, StateReady, "")
+	select {
+	case v := <-ch:
+		t.Errorf("received an unexpected update: %+v", v)
+	default:
+	}
+
+	// the channel is closed on unsubscription and no longer notified
+	unsubscribe()
+	unsubscribe()
+	b.Set(componentDiscovery, StateFailed, "failed")
+	if _, ok := <-ch; ok {
+		t.Error("the channel is not closed after unsubscription")
+	}
+
+	statuses := b.Snapshot()
+	if statuses.Alive() {
+		t.Error("must not be alive with a failed component")
+	}
+	if statuses.Ready() {
+		t.Error("must not be ready with a failed component")
+	}
+}
+
+func TestStateBroadcasterUnsubscribeOthers(t *testing.T) {
+	b := newStateBroadcaster(componentDiscovery)
+
+	ch1, unsubscribe1 := b.Subscribe()
+	ch2, unsubscribe2 := b.Subscribe()
+	defer unsubscribe2()
+	<-ch1
+	<-ch2
+
+	// unsubscribing a subscriber does not affect the others
+	unsubscribe1()
+	b.Set(componentDiscovery, StateReady, "")
+	got := <-ch2
+	if state := got[componentDiscovery].State; state != StateReady {
+		t.Errorf("unexpected state. got: %s, want: %s", state, StateReady)
+	}
+}
@@ -3,32 +3,75 @@ package handler
 import (
 	"encoding/json"
 	"net/http"
+	"sort"
+	"time"
+
+	"github.com/prometheus/common/model"
 )
 
-type responseStatusTarget struct {
-	URL  string `json:"url"`
-	Hash string `json:"hash"`
+// ResponseStatusTarget is a target in the response of status API.
+type ResponseStatusTarget struct {
+	URL        string                     `json:"url"`
+	Hash       string                     `json:"hash"`
+	Containers []*ResponseStatusContainer `json:"containers,omitempty"`
+	LastScrape *ScrapeResult              `json:"last_scrape,omitempty"`
+}
+
+// ResponseStatusContainer is a container which exposes a target.
+type ResponseStatusContainer struct {
+	ID     string         `json:"id,omitempty"`
+	Name   string         `json:"name,omitempty"`
+	Image  string         `json:"image,omitempty"`
+	Labels model.LabelSet `json:"labels,omitempty"`
 }
 
-type responseStatus struct {
-	Targets []*responseStatusTarget `json:"targets"`
-	Config  HandlerParams           `json:"config"`
+// ResponseStatus is the response of status API.
+type ResponseStatus struct {
+	Targets []*ResponseStatusTarget `json:"targets"`
+	// LastDiscovery is the time when the targets are updated by discovery last.
+	LastDiscovery *time.Time    `json:"last_discovery,omitempty"`
+	Config        HandlerParams `json:"config"`
 }
 
 func (h *Handler) endpointStatus(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(h.status(tenantFromContext(r.Context())))
+}
+
+// status returns the current targets of the tenant sorted by URL and the configuration.
+// All the targets are returned if t is nil.
+func (h *Handler) status(t *tenant) *ResponseStatus {
 	h.targetsMutex.RLock()
 	defer h.targetsMutex.RUnlock()
-	status := &responseStatus{
-		Config: *h.config,
+	status := &ResponseStatus{
+		Config: *h.configFor(t),
 	}
-	for hash, url := range h.proxies {
-		status.Targets = append(status.Targets, &responseStatusTarget{
-			URL:  url.String(),
-			Hash: hash,
-		})
+	if !h.lastDiscovery.IsZero() {
+		lastDiscovery := h.lastDiscovery
+		status.LastDiscovery = &lastDiscovery
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(status)
+	for hash, pt := range h.registry.all() {
+		if !t.allows(hash) {
+			continue
+		}
+		target := &ResponseStatusTarget{
+			URL:        pt.url.String(),
+			Hash:       hash,
+			LastScrape: pt.stats.lastScrape.Load(),
+		}
+		for _, ls := range pt.labels {
+			target.Containers = append(target.Containers, &ResponseStatusContainer{
+				ID:     string(ls[labelNameContainerID]),
+				Name:   string(ls[labelNameContainerName]),
+				Image:  containerImage(ls),
+				Labels: ls,
+			})
+		}
+		status.Targets = append(status.Targets, target)
+	}
+	sort.Slice(status.Targets, func(i, j int) bool {
+		return status.Targets[i].URL < status.Targets[j].URL
+	})
+	return status
 }
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+func TestEndpointStatus(t *testing.T) {
+	ctx := t.Context()
+
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+		io.WriteString(w, "up 1\n")
+	}))
+	defer exporter.Close()
+	exporterURL, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	labels := model.LabelSet{
+		labelNameAddressLabel:  model.LabelValue(exporterURL.Host),
+		labelNameContainerID:   "0123456789ab",
+		labelNameContainerName: "/exporter",
+	}
+	tg := []*targetgroup.Group{{Targets: []model.LabelSet{labels}}}
+	handler, err := createTestHandler(t, tg, &HandlerParams{DiscovererParams: &DiscovererParams{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		handler.Run(ctx)
+	}()
+	err = handler.WaitReady(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scrapeURL := "http://" + exporterURL.Host + defaultMetricPath
+	hash := endpointHash(scrapeURL)
+
+	// scrape once to record the result
+	r := httptest.NewRequest(http.MethodGet, "/proxy/"+hash, nil)
+	r = mux.SetURLVars(r, map[string]string{"source": hash})
+	handler.endpointProxy(httptest.NewRecorder(), r)
+
+	r = httptest.NewRequest(http.MethodGet, "/status", nil)
+	w := httptest.NewRecorder()
+	handler.endpointStatus(w, r)
+	res := w.Result()
+	defer res.Body.Close()
+	var got ResponseStatus
+	err = json.NewDecoder(res.Body).Decode(&got)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []*ResponseStatusTarget{
+		{
+			URL:  scrapeURL,
+			Hash: hash,
+			Containers: []*ResponseStatusContainer{
+				{
+					ID:     "0123456789ab",
+					Name:   "/exporter",
+					Labels: labels,
+				},
+			},
+			LastScrape: &ScrapeResult{StatusCode: http.StatusTeapot, Size: 5},
+		},
+	}
+	if diff := cmp.Diff(got.Targets, want, cmpopts.IgnoreFields(ScrapeResult{}, "Time", "Duration")); diff != "" {
+		t.Errorf("unexpected targets. diff(-got, +want): %s", diff)
+	}
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+const (
+	// labelNameContainerID is the label of Docker SD for the ID of container.
+	labelNameContainerID = model.LabelName(model.MetaLabelPrefix + "docker_container_id")
+	// labelNameContainerName is the label of Docker SD for the name of container.
+	labelNameContainerName = model.LabelName(model.MetaLabelPrefix + "docker_container_name")
+	// labelNameContainerLabelPrefix is the prefix of the labels of Docker SD for container labels.
+	labelNameContainerLabelPrefix = model.MetaLabelPrefix + "docker_container_label_"
+)
+
+// imageLabels are the labels to tell the image of container, in the order of priority.
+// Docker SD does not expose the image, so it is taken from the OCI annotations
+// which containers inherit from their images.
+var imageLabels = []model.LabelName{
+	labelNameContainerLabelPrefix + "org_opencontainers_image_ref_name",
+	labelNameContainerLabelPrefix + "org_opencontainers_image_title",
+}
+
+// containerImage returns the image of the container discovered with ls, or empty string if unknown.
+func containerImage(ls model.LabelSet) string {
+	for _, name := range imageLabels {
+		if v, ok := ls[name]; ok && v != "" {
+			return string(v)
+		}
+	}
+	return ""
+}
+
+// proxyTarget is an exporter endpoint served by the reverse proxy.
+// It is immutable once registered except for stats.
+type proxyTarget struct {
+	url  *url.URL
+	hash string
+	// source is the source of the target group which the target is discovered first in.
+	source string
+	// labels are the labels discovered for the containers sharing the URL.
+	labels []model.LabelSet
+	// proxy is the reverse proxy to the URL, built at registration.
+	proxy *httputil.ReverseProxy
+	// stats is the runtime data of the target, kept across updates of the targets.
+	stats *targetStats
+}
+
+// containerName returns the name of the first container of the target without the leading slash.
+func (pt *proxyTarget) containerName() string {
+	if len(pt.labels) == 0 {
+		return ""
+	}
+	return strings.TrimPrefix(string(pt.labels[0][labelNameContainerName]), "/")
+}
+
+// targetStats is the runtime data of a target.
+type targetStats struct {
+	metrics *targetMetrics
+	// firstSeen is the time when the target is discovered first. It is immutable.
+	firstSeen  time.Time
+	lastSeen   atomic.Pointer[time.Time]
+	lastScrape atomic.Pointer[ScrapeResult]
+}
+
+// quarantinedTarget is a target discovered but not served, because its URL could not be generated.
+type quarantinedTarget struct {
+	source string
+	labels model.LabelSet
+	reason string
+}
+
+// ScrapeResult is the result of a request to the exporter via the reverse proxy.
+type ScrapeResult struct {
+	Time       time.Time `json:"time"`
+	StatusCode int       `json:"status_code"`
+	// Duration is the time taken to proxy the response.
+	Duration time.Duration `json:"duration"`
+	// Size is the size of the response body in bytes.
+	Size int64 `json:"size"`
+	// Error is the error occurred to request the exporter, if any.
+	Error string `json:"error,omitempty"`
+}
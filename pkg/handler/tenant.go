@@ -0,0 +1,291 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/prometheus/common/model"
+)
+
+// redactedSecret replaces the secrets in the configuration shown to users.
+const redactedSecret = "xxxxx"
+
+// TenantParams is the parameters of a tenant, which sees and scrapes only the targets of its own containers.
+// Once any tenant is configured, the requests except health checks and `/metrics` must have the bearer token of a tenant.
+// A target belongs to the tenant only if all the containers behind it do, so the targets shared among tenants are hidden from all of them.
+// `/metrics` exposes the names of tenants and the labels of the containers of all the tenants,
+// so it should be protected by HandlerParams.MetricsBearerToken.
+type TenantParams struct {
+	Name string `json:"name" yaml:"name"`
+	// BearerToken authenticates the requests of the tenant. It is read from BearerTokenFile if empty.
+	BearerToken     string `json:"bearer_token,omitempty" yaml:"bearer_token"`
+	BearerTokenFile string `json:"bearer_token_file,omitempty" yaml:"bearer_token_file"`
+	// Selector selects the containers of the tenant in the same way as the one of ViewParams.
+	Selector map[string]string `json:"selector" yaml:"selector"`
+}
+
+// tenant is the compiled TenantParams.
+type tenant struct {
+	name     string
+	token    []byte
+	selector map[model.LabelName]*regexp.Regexp
+	// hashes are the targets of the tenant.
+	hashes atomic.Pointer[map[string]struct{}]
+	// sdSnapshots are the snapshots of service discovery of the tenant keyed by the names of views.
+	// The key of the snapshot of all the targets is empty.
+	sdSnapshots atomic.Pointer[map[string]*sdSnapshot]
+}
+
+func newTenant(params *TenantParams) (*tenant, error) {
+	if params.Name == "" {
+		return nil, errors.New("name of tenant must be specified")
+	}
+	token, err := readBearerToken(params.BearerToken, params.BearerTokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bearer token of tenant `%s`: %w", params.Name, err)
+	}
+	if token == "" {
+		return nil, fmt.Errorf("bearer token of tenant `%s` must be specified", params.Name)
+	}
+	if len(params.Selector) == 0 {
+		// a tenant without selector would see all the containers, which is what tenants are to prevent
+		return nil, fmt.Errorf("selector of tenant `%s` must be specified", params.Name)
+	}
+	selector, err := compileSelector(params.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("invalid selector of tenant `%s`: %w", params.Name, err)
+	}
+	t := &tenant{
+		name:     params.Name,
+		token:    []byte(token),
+		selector: selector,
+	}
+	t.hashes.Store(&map[string]struct{}{})
+	t.sdSnapshots.Store(&map[string]*sdSnapshot{})
+	return t, nil
+}
+
+// readBearerToken returns token, or the content of file if token is empty.
+func readBearerToken(token, file string) (string, error) {
+	if token != "" || file == "" {
+		return token, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+// newTenants compiles the tenants.
+func newTenants(params []*TenantParams) ([]*tenant, error) {
+	var tenants []*tenant
+	names := make(map[string]struct{}, len(params))
+	for _, p := range params {
+		if p == nil {
+			return nil, errors.New("tenant is empty")
+		}
+		if _, ok := names[p.Name]; ok {
+			return nil, fmt.Errorf("duplicated name of tenant `%s`", p.Name)
+		}
+		names[p.Name] = struct{}{}
+		t, err := newTenant(p)
+		if err != nil {
+			return nil, err
+		}
+		for _, other := range tenants {
+			if subtle.ConstantTimeCompare(t.token, other.token) == 1 {
+				return nil, fmt.Errorf("tenants `%s` and `%s` share the same bearer token", other.name, t.name)
+			}
+		}
+		tenants = append(tenants, t)
+	}
+	return tenants, nil
+}
+
+// selects reports whether the container discovered with ls belongs to the tenant.
+// Every container belongs to nil tenant, which stands for the requests without tenants.
+func (t *tenant) selects(ls model.LabelSet) bool {
+	return t == nil || matchSelector(t.selector, ls)
+}
+
+// allows reports whether the tenant can see and scrape the target of hash.
+func (t *tenant) allows(hash string) bool {
+	if t == nil {
+		return true
+	}
+	_, ok := (*t.hashes.Load())[hash]
+	return ok
+}
+
+// sdSnapshot returns the snapshot of service discovery of the tenant for the view, or all if view is empty.
+// base is the snapshot for all the tenants, which nil tenant returns as is.
+func (t *tenant) sdSnapshot(view string, base *sdSnapshot) *sdSnapshot {
+	if t == nil {
+		return base
+	}
+	if s, ok := (*t.sdSnapshots.Load())[view]; ok {
+		return s
+	}
+	return &sdSnapshot{modified: base.modified}
+}
+
+// selectsAll reports whether all the containers discovered with labels belong to the tenant.
+func (t *tenant) selectsAll(labels []model.LabelSet) bool {
+	return !slices.ContainsFunc(labels, func(ls model.LabelSet) bool {
+		return !t.selects(ls)
+	})
+}
+
+// tenantState is the targets and the snapshots of service discovery of a tenant,
+// which are built before and published together with the targets.
+type tenantState struct {
+	hashes    map[string]struct{}
+	snapshots map[string]*sdSnapshot
+}
+
+// buildTenantStates builds the states of the tenants in the order of h.tenants.
+// snapshots are the snapshots for all the tenants keyed by the names of views.
+// It is called only from the goroutine updating the targets.
+func (h *Handler) buildTenantStates(targets map[string]*proxyTarget, snapshots map[string]*sdSnapshot) ([]*tenantState, error) {
+	now := time.Now()
+	states := make([]*tenantState, 0, len(h.tenants))
+	for _, t := range h.tenants {
+		hashes := make(map[string]struct{})
+		for hash, pt := range targets {
+			// a target deduplicated from the containers of several tenants belongs to none of them,
+			// otherwise a tenant could point its container at the exporter of another one by the override labels,
+			// and see it with the labels of the other one
+			if t.selectsAll(pt.labels) {
+				hashes[hash] = struct{}{}
+			} else if slices.ContainsFunc(pt.labels, t.selects) {
+				h.logger.Warn(
+					"target is hidden from tenant since it is shared with the containers of others",
+					"tenant", t.name,
+					"hash", hash,
+				)
+			}
+		}
+
+		prev := *t.sdSnapshots.Load()
+		tenantSnapshots := make(map[string]*sdSnapshot, len(snapshots))
+		for view, base := range snapshots {
+			var entries []*sdEntry
+			for _, e := range base.entries {
+				if _, ok := hashes[e.hash]; ok {
+					entries = append(entries, e)
+				}
+			}
+			s, err := newSDSnapshot(entries, prev[view], now)
+			if err != nil {
+				return nil, fmt.Errorf("failed to build the snapshot of tenant `%s`: %w", t.name, err)
+			}
+			tenantSnapshots[view] = s
+		}
+		states = append(states, &tenantState{hashes: hashes, snapshots: tenantSnapshots})
+	}
+	return states, nil
+}
+
+// publishTenantStates stores the states built by buildTenantStates.
+func (h *Handler) publishTenantStates(states []*tenantState) {
+	for i, state := range states {
+		t := h.tenants[i]
+		t.hashes.Store(&state.hashes)
+		t.sdSnapshots.Store(&state.snapshots)
+		h.metrics.tenantTargets.WithLabelValues(t.name).Set(float64(len(state.hashes)))
+	}
+}
+
+// tenantContextKey is the key of the tenant of the request in its context.
+type tenantContextKey struct{}
+
+// tenantFromContext returns the tenant of the request, or nil if tenants are not configured.
+func tenantFromContext(ctx context.Context) *tenant {
+	t, _ := ctx.Value(tenantContextKey{}).(*tenant)
+	return t
+}
+
+// bearerToken returns the bearer token of r, or empty if r does not have it.
+func bearerToken(r *http.Request) string {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(token)
+}
+
+// authenticate returns the tenant who has the bearer token of r, or nil if no tenant has it.
+func (h *Handler) authenticate(r *http.Request) *tenant {
+	token := bearerToken(r)
+	if token == "" {
+		return nil
+	}
+	var ret *tenant
+	// compare with all the tenants not to tell which one matches by the time it takes
+	for _, t := range h.tenants {
+		if subtle.ConstantTimeCompare([]byte(token), t.token) == 1 {
+			ret = t
+		}
+	}
+	return ret
+}
+
+// withTenant lets only the requests of tenants reach handler if tenants are configured,
+// and records the usage of each tenant.
+func (h *Handler) withTenant(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if len(h.tenants) == 0 {
+			handler(w, r)
+			return
+		}
+		t := h.authenticate(r)
+		if t == nil {
+			h.metrics.tenantUnauthorized.Inc()
+			w.Header().Set("WWW-Authenticate", `Bearer realm="prommux"`)
+			http.Error(w, "valid bearer token of a tenant is required", http.StatusUnauthorized)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(rec, r.WithContext(context.WithValue(r.Context(), tenantContextKey{}, t)))
+		h.metrics.tenantRequests.WithLabelValues(t.name, name, strconv.Itoa(rec.status)).Inc()
+		h.metrics.tenantResponseSize.WithLabelValues(t.name, name).Add(float64(rec.size))
+	}
+}
+
+// withMetricsToken lets only the requests with the bearer token for metrics reach handler if it is configured.
+func (h *Handler) withMetricsToken(handler http.HandlerFunc) http.HandlerFunc {
+	if len(h.metricsToken) == 0 {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		if subtle.ConstantTimeCompare([]byte(bearerToken(r)), h.metricsToken) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="prommux"`)
+			http.Error(w, "valid bearer token for metrics is required", http.StatusUnauthorized)
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// configFor returns the configuration shown to the tenant with secrets redacted.
+// The tenant sees only itself out of the tenants.
+func (h *Handler) configFor(t *tenant) *HandlerParams {
+	config := h.config.redacted()
+	if t != nil {
+		config.Tenants = slices.DeleteFunc(config.Tenants, func(tp *TenantParams) bool {
+			return tp.Name != t.name
+		})
+	}
+	return config
+}
@@ -0,0 +1,342 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+)
+
+// tenantTarget returns the labels of a target of the team.
+func tenantTarget(address, team string) model.LabelSet {
+	return model.LabelSet{
+		labelNameAddressLabel:                              model.LabelValue(address),
+		labelNameContainerLabelPrefix + "com_example_team": model.LabelValue(team),
+	}
+}
+
+func TestTenants(t *testing.T) {
+	exporter := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if auth := r.Header.Get("Authorization"); auth != "" {
+			t.Errorf("the token of the tenant must not be forwarded to the exporter. got: %s", auth)
+		}
+		io.WriteString(w, "up 1\n")
+	}))
+	defer exporter.Close()
+	u, err := url.Parse(exporter.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err = os.WriteFile(tokenFile, []byte("search-token\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		Views:            []*ViewParams{{Name: "all"}},
+		Tenants: []*TenantParams{
+			{Name: "payments", BearerToken: "payments-token", Selector: map[string]string{"com.example.team": "payments"}},
+			{Name: "search", BearerTokenFile: tokenFile, Selector: map[string]string{"com.example.team": "search"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{
+			tenantTarget(u.Host, "payments"),
+			tenantTarget("search:9100", "search"),
+			tenantTarget("infra:9100", "infra"),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	paymentsHash := endpointHash("http://" + u.Host + "/metrics")
+	searchHash := endpointHash("http://search:9100/metrics")
+
+	server := httptest.NewServer(h.NewRouter())
+	defer server.Close()
+	request := func(path, token string) *http.Response {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, server.URL+path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		return res
+	}
+
+	for path, token := range map[string]string{
+		"/discover":            "",
+		"/status":              "wrong-token",
+		"/proxy/" + searchHash: "",
+	} {
+		if res := request(path, token); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("unexpected status code of %s without valid token. got: %d, want: %d", path, res.StatusCode, http.StatusUnauthorized)
+		}
+	}
+	if res := request("/-/healthy", ""); res.StatusCode != http.StatusOK {
+		t.Errorf("health checks must not need tokens. got: %d", res.StatusCode)
+	}
+
+	for _, tc := range []struct {
+		path, token string
+		want        int
+	}{
+		{"/discover", "payments-token", 1},
+		{"/discover", "search-token", 1},
+		{"/discover/all", "search-token", 1},
+	} {
+		var configs []*StaticConfig
+		err := json.NewDecoder(request(tc.path, tc.token).Body).Decode(&configs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(configs) != tc.want {
+			t.Errorf("unexpected number of targets of %s. got: %d, want: %d", tc.path, len(configs), tc.want)
+		}
+	}
+
+	var status ResponseStatus
+	err = json.NewDecoder(request("/status", "payments-token").Body).Decode(&status)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(status.Targets) != 1 || status.Targets[0].Hash != paymentsHash {
+		t.Errorf("unexpected targets of status: %+v", status.Targets)
+	}
+	if len(status.Config.Tenants) != 1 || status.Config.Tenants[0].Name != "payments" || status.Config.Tenants[0].BearerToken != redactedSecret {
+		t.Errorf("the tenant must see only itself with the token redacted: %+v", status.Config.Tenants)
+	}
+
+	if res := request("/proxy/"+paymentsHash, "payments-token"); res.StatusCode != http.StatusOK {
+		t.Errorf("unexpected status code of the proxy to the own target. got: %d", res.StatusCode)
+	}
+	if res := request("/proxy/"+searchHash, "payments-token"); res.StatusCode != http.StatusNotFound {
+		t.Errorf("the target of the other tenant must be hidden. got: %d", res.StatusCode)
+	}
+
+	if got := testutil.ToFloat64(h.metrics.tenantTargets.WithLabelValues("search")); got != 1 {
+		t.Errorf("unexpected number of targets of search. got: %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.tenantRequests.WithLabelValues("payments", "proxy", "404")); got != 1 {
+		t.Errorf("unexpected count of requests of payments. got: %v", got)
+	}
+	if got := testutil.ToFloat64(h.metrics.tenantUnauthorized); got != 3 {
+		t.Errorf("unexpected count of unauthorized requests. got: %v", got)
+	}
+}
+
+func TestTenantsSharedTarget(t *testing.T) {
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{IncludeDockerLabels: true},
+		Tenants: []*TenantParams{
+			{Name: "payments", BearerToken: "payments-token", Selector: map[string]string{"com.example.team": "payments"}},
+			{Name: "search", BearerToken: "search-token", Selector: map[string]string{"com.example.team": "search"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the container of search points at the exporter of payments by the override labels
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{
+			tenantTarget("payments-api:9100", "payments"),
+			tenantTarget("payments-api:9100", "payments"),
+			tenantTarget("payments-db:9187", "payments"),
+			tenantTarget("payments-db:9187", "search"),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	replicasHash := endpointHash("http://payments-api:9100/metrics")
+	sharedHash := endpointHash("http://payments-db:9187/metrics")
+	payments, search := h.tenants[0], h.tenants[1]
+
+	t.Run("replicas of a tenant", func(t *testing.T) {
+		if !payments.allows(replicasHash) {
+			t.Error("the target of the replicas of the tenant must be allowed")
+		}
+		if search.allows(replicasHash) {
+			t.Error("the target of the other tenant must not be allowed")
+		}
+	})
+
+	t.Run("across tenants", func(t *testing.T) {
+		if search.allows(sharedHash) {
+			t.Error("the target shared with the other tenant must not be allowed")
+		}
+		if payments.allows(sharedHash) {
+			t.Error("the target shared with the other tenant must not be allowed to the owner either")
+		}
+		for _, tn := range h.tenants {
+			for _, e := range tn.sdSnapshot("", h.sdSnapshot.Load()).entries {
+				if e.hash == sharedHash {
+					t.Errorf("the target shared with the other tenant must not be discovered by `%s`", tn.name)
+				}
+			}
+		}
+	})
+
+	if got := testutil.ToFloat64(h.metrics.tenantTargets.WithLabelValues("payments")); got != 1 {
+		t.Errorf("unexpected number of targets of payments. got: %v", got)
+	}
+}
+
+func TestTenantsExplain(t *testing.T) {
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		Tenants: []*TenantParams{
+			{Name: "payments", BearerToken: "payments-token", Selector: map[string]string{"com.example.team": "payments"}},
+			{Name: "search", BearerToken: "search-token", Selector: map[string]string{"com.example.team": "search"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	container := func(name, address, team string) model.LabelSet {
+		ls := tenantTarget(address, team)
+		ls[labelNameContainerName] = model.LabelValue("/" + name)
+		return ls
+	}
+	// search-db points at the exporter of payments-db by the override labels
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{
+			container("payments-api-1", "payments-api:9100", "payments"),
+			container("payments-api-2", "payments-api:9100", "payments"),
+			container("payments-db", "payments-db:9187", "payments"),
+			container("search-db", "payments-db:9187", "search"),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	router := h.NewRouter()
+	explain := func(name, token string) (int, []*Explanation) {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/debug/explain/"+name, nil)
+		r.Header.Set("Authorization", "Bearer "+token)
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, r)
+		var explanations []*Explanation
+		if w.Code == http.StatusOK {
+			err := json.NewDecoder(w.Body).Decode(&explanations)
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return w.Code, explanations
+	}
+
+	code, explanations := explain("payments-api-2", "payments-token")
+	if code != http.StatusOK || len(explanations) != 1 || explanations[0].Dedup.DuplicateOf != "/payments-api-1" {
+		t.Errorf("the replica of the tenant must be explained. code: %d, explanations: %+v", code, explanations)
+	}
+	for _, tc := range []struct{ name, token string }{
+		{"payments-api-1", "search-token"},
+		{"payments-db", "payments-token"},
+		{"search-db", "search-token"},
+	} {
+		if code, explanations := explain(tc.name, tc.token); code != http.StatusNotFound {
+			t.Errorf("the target of `%s` must be hidden from the tenant of `%s`. code: %d, explanations: %+v", tc.name, tc.token, code, explanations)
+		}
+	}
+	if explanations := h.Explain("search-db", "http", "prommux:11298"); len(explanations) != 1 || explanations[0].Dedup.DuplicateOf != "/payments-db" {
+		t.Errorf("the target must be explained without tenants. explanations: %+v", explanations)
+	}
+}
+
+func TestMetricsBearerToken(t *testing.T) {
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	err := os.WriteFile(tokenFile, []byte("metrics-token\n"), 0o600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tenants := []*TenantParams{{Name: "payments", BearerToken: "payments-token", Selector: map[string]string{"com.example.team": "payments"}}}
+
+	for name, tc := range map[string]struct {
+		params *HandlerParams
+		want   map[string]int
+	}{
+		"public": {
+			params: &HandlerParams{DiscovererParams: &DiscovererParams{}, Tenants: tenants},
+			want:   map[string]int{"": http.StatusOK, "payments-token": http.StatusOK},
+		},
+		"token": {
+			params: &HandlerParams{DiscovererParams: &DiscovererParams{}, Tenants: tenants, MetricsBearerToken: "metrics-token"},
+			want:   map[string]int{"": http.StatusUnauthorized, "payments-token": http.StatusUnauthorized, "metrics-token": http.StatusOK},
+		},
+		"token file": {
+			params: &HandlerParams{DiscovererParams: &DiscovererParams{}, MetricsBearerTokenFile: tokenFile},
+			want:   map[string]int{"": http.StatusUnauthorized, "metrics-token": http.StatusOK},
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, err := createTestHandler(t, nil, tc.params)
+			if err != nil {
+				t.Fatal(err)
+			}
+			router := h.NewRouter()
+			for token, want := range tc.want {
+				r := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+				if token != "" {
+					r.Header.Set("Authorization", "Bearer "+token)
+				}
+				w := httptest.NewRecorder()
+				router.ServeHTTP(w, r)
+				if w.Code != want {
+					t.Errorf("unexpected status code with token `%s`. got: %d, want: %d", token, w.Code, want)
+				}
+			}
+			if got := h.config.redacted().MetricsBearerToken; got != "" && got != redactedSecret {
+				t.Errorf("the bearer token for metrics must be redacted. got: %s", got)
+			}
+		})
+	}
+
+	_, err = createTestHandler(t, nil, &HandlerParams{MetricsBearerTokenFile: filepath.Join(t.TempDir(), "missing")})
+	if err == nil {
+		t.Error("missing file of bearer token for metrics must be rejected")
+	}
+}
+
+func TestNewTenantsInvalid(t *testing.T) {
+	selector := map[string]string{"com.example.team": "payments"}
+	for name, params := range map[string][]*TenantParams{
+		"no name":        {{BearerToken: "token", Selector: selector}},
+		"no token":       {{Name: "payments", Selector: selector}},
+		"no token file":  {{Name: "payments", BearerTokenFile: filepath.Join(t.TempDir(), "missing"), Selector: selector}},
+		"no selector":    {{Name: "payments", BearerToken: "token"}},
+		"duplicated":     {{Name: "payments", BearerToken: "a", Selector: selector}, {Name: "payments", BearerToken: "b", Selector: selector}},
+		"shared token":   {{Name: "payments", BearerToken: "token", Selector: selector}, {Name: "search", BearerToken: "token", Selector: selector}},
+		"invalid regexp": {{Name: "payments", BearerToken: "token", Selector: map[string]string{"team": "("}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newTenants(params)
+			if err == nil {
+				t.Error("error must be returned")
+			}
+		})
+	}
+}
@@ -16,13 +16,10 @@ type OverrideLabelsTemplateParams struct {
 	OriginalHost, OriginalPort, OriginalMetricsPath string
 }
 
-var (
-	overrideLabelsTemplate = template.New("override_labels_template")
-)
-
 func applyOverrideLabelsTemplate(s string, param *OverrideLabelsTemplateParams) (string, error) {
 	out := new(bytes.Buffer)
-	tmpl, err := overrideLabelsTemplate.Parse(s)
+	// parse into a new template every time, since Parse modifies the template
+	tmpl, err := template.New("override_labels_template").Parse(s)
 	if err != nil {
 		return "", fmt.Errorf("failed to parse template for override Labels: %w", err)
 	}
@@ -38,6 +35,17 @@ func applyOverrideLabelsTemplate(s string, param *OverrideLabelsTemplateParams)
 // It generates URL to scrape by the labels named `__scheme__`, `__address__` and `__metrics_path`.
 // Additionally, these configuration can be overridden by `prommux.scheme`, `prommux.address`, and `prommux.metrics_path`.
 func geneateURLFromLabels(ls model.LabelSet) (*url.URL, error) {
+	return traceURLFromLabels(ls, nil)
+}
+
+// urlTrace records how traceURLFromLabels generates the URL.
+type urlTrace struct {
+	params    *OverrideLabelsTemplateParams
+	overrides []*ExplainOverride
+}
+
+// traceURLFromLabels is geneateURLFromLabels which records each step into trace if it is not nil.
+func traceURLFromLabels(ls model.LabelSet, trace *urlTrace) (*url.URL, error) {
 	// get URL from Prometheus reserved labels
 	scheme := string(ls[labelNameSchemeLabel])
 	if scheme == "" {
@@ -60,24 +68,41 @@ func geneateURLFromLabels(ls model.LabelSet) (*url.URL, error) {
 		OriginalPort:        originalPort,
 		OriginalMetricsPath: string(path),
 	}
+	if trace != nil {
+		trace.params = params
+	}
 
 	// override URL from Prommux override labels
-	if v, ok := ls[labelNameOverrideSchemeLabel]; ok {
-		scheme, err = applyOverrideLabelsTemplate(string(v), params)
-		if err != nil {
-			return nil, fmt.Errorf("failed to apply template for `scheme`: %w", err)
-		}
+	overrides := []struct {
+		name      string
+		labelName model.LabelName
+		value     *string
+	}{
+		{name: "scheme", labelName: labelNameOverrideSchemeLabel, value: &scheme},
+		{name: "host", labelName: labelNameOverrideAddressLabel, value: &host},
+		{name: "path", labelName: labelNameOverrideMetricsPathLabel, value: &path},
 	}
-	if v, ok := ls[labelNameOverrideAddressLabel]; ok {
-		host, err = applyOverrideLabelsTemplate(string(v), params)
-		if err != nil {
-			return nil, fmt.Errorf("failed to apply template for `host`: %w", err)
+	for _, o := range overrides {
+		v, ok := ls[o.labelName]
+		if !ok {
+			continue
+		}
+		before := *o.value
+		*o.value, err = applyOverrideLabelsTemplate(string(v), params)
+		if trace != nil {
+			step := &ExplainOverride{
+				Label:    string(o.labelName),
+				Template: string(v),
+				Before:   before,
+				After:    *o.value,
+			}
+			if err != nil {
+				step.Error = err.Error()
+			}
+			trace.overrides = append(trace.overrides, step)
 		}
-	}
-	if v, ok := ls[labelNameOverrideMetricsPathLabel]; ok {
-		path, err = applyOverrideLabelsTemplate(string(v), params)
 		if err != nil {
-			return nil, fmt.Errorf("failed to apply template for `path`: %w", err)
+			return nil, fmt.Errorf("failed to apply template for `%s`: %w", o.name, err)
 		}
 	}
 
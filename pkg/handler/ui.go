@@ -0,0 +1,166 @@
+package handler
+
+import (
+	"bytes"
+	"embed"
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+)
+
+//go:embed ui
+var uiFS embed.FS
+
+// uiPages are the templates of the pages of web UI, keyed by the file name.
+var uiPages = parseUIPages("targets.html", "target.html", "config.html")
+
+var uiFuncs = template.FuncMap{
+	"ago": func(t time.Time) string {
+		return time.Since(t).Truncate(time.Second).String()
+	},
+	"duration": func(d time.Duration) string {
+		return d.Round(time.Microsecond).String()
+	},
+	"bytes": func(n int64) string {
+		switch {
+		case n >= 1<<20:
+			return fmt.Sprintf("%.1f MiB", float64(n)/(1<<20))
+		case n >= 1<<10:
+			return fmt.Sprintf("%.1f KiB", float64(n)/(1<<10))
+		default:
+			return fmt.Sprintf("%d B", n)
+		}
+	},
+	"ok": func(code int) bool {
+		return code >= 200 && code < 300
+	},
+}
+
+func parseUIPages(names ...string) map[string]*template.Template {
+	ret := make(map[string]*template.Template, len(names))
+	for _, name := range names {
+		ret[name] = template.Must(
+			template.New(name).Funcs(uiFuncs).ParseFS(uiFS, "ui/templates/layout.html", "ui/templates/"+name),
+		)
+	}
+	return ret
+}
+
+// uiTarget is a target shown on web UI.
+type uiTarget struct {
+	*ResponseStatusTarget
+	ProxyPath string
+	// StaticConfigLabels are the labels of the target in the response of service discovery.
+	StaticConfigLabels model.LabelSet
+}
+
+// uiTargetsPage is the data of the page to list targets.
+type uiTargetsPage struct {
+	Title         string
+	Components    ComponentStatuses
+	LastDiscovery *time.Time
+	Targets       []*uiTarget
+}
+
+// uiTargetPage is the data of the page of a target.
+type uiTargetPage struct {
+	Title  string
+	Target *uiTarget
+}
+
+// uiConfigPage is the data of the page of the configuration.
+type uiConfigPage struct {
+	Title  string
+	Config string
+}
+
+func (h *Handler) newUITarget(t *ResponseStatusTarget) *uiTarget {
+	ret := &uiTarget{
+		ResponseStatusTarget: t,
+		ProxyPath:            "/proxy/" + t.Hash,
+	}
+	for _, e := range h.sdSnapshot.Load().entries {
+		if e.hash == t.Hash {
+			ret.StaticConfigLabels = e.labels
+			break
+		}
+	}
+	return ret
+}
+
+// renderUIPage renders the page with data.
+// It is rendered into the buffer first not to send a broken page on errors.
+func (h *Handler) renderUIPage(w http.ResponseWriter, r *http.Request, name string, data any) {
+	var buf bytes.Buffer
+	err := uiPages[name].ExecuteTemplate(&buf, "layout", data)
+	if err != nil {
+		h.logger.ErrorContext(r.Context(), "failed to render web UI", "page", name, "error", err)
+		http.Error(w, fmt.Sprintf("failed to render page. err: %s", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	buf.WriteTo(w)
+}
+
+// endpointUITargets serves the page to list the targets and the state of discovery.
+func (h *Handler) endpointUITargets(w http.ResponseWriter, r *http.Request) {
+	status := h.status(tenantFromContext(r.Context()))
+	page := &uiTargetsPage{
+		Title:         "Targets",
+		Components:    h.states.Snapshot(),
+		LastDiscovery: status.LastDiscovery,
+	}
+	for _, t := range status.Targets {
+		page.Targets = append(page.Targets, h.newUITarget(t))
+	}
+	h.renderUIPage(w, r, "targets.html", page)
+}
+
+// endpointUITarget serves the page of a target.
+func (h *Handler) endpointUITarget(w http.ResponseWriter, r *http.Request) {
+	hash := mux.Vars(r)["hash"]
+	for _, t := range h.status(tenantFromContext(r.Context())).Targets {
+		if t.Hash == hash {
+			h.renderUIPage(w, r, "target.html", &uiTargetPage{
+				Title:  t.URL,
+				Target: h.newUITarget(t),
+			})
+			return
+		}
+	}
+	http.Error(w, "missing target", http.StatusNotFound)
+}
+
+// endpointUIConfig serves the page of the effective configuration with secrets redacted.
+func (h *Handler) endpointUIConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := json.MarshalIndent(h.configFor(tenantFromContext(r.Context())), "", "  ")
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode config. err: %s", err), http.StatusInternalServerError)
+		return
+	}
+	h.renderUIPage(w, r, "config.html", &uiConfigPage{
+		Title:  "Configuration",
+		Config: string(config),
+	})
+}
+
+// handleUI registers the endpoints of web UI on r, with the helpers of NewRouter to instrument them.
+func (h *Handler) handleUI(r *mux.Router, handle, handlePublic func(path, name string, handler http.HandlerFunc)) {
+	static, err := fs.Sub(uiFS, "ui")
+	if err != nil {
+		// never happens as the directory is embedded
+		panic(err)
+	}
+	handlePublic("/ui", "ui_targets", http.RedirectHandler("/ui/", http.StatusMovedPermanently).ServeHTTP)
+	handle("/ui/", "ui_targets", h.endpointUITargets)
+	handle("/ui/targets/{hash}", "ui_target", h.endpointUITarget)
+	handle("/ui/config", "ui_config", h.endpointUIConfig)
+	r.PathPrefix("/ui/static/").Handler(h.metrics.instrument("ui_static", http.StripPrefix("/ui/", http.FileServerFS(static)).ServeHTTP))
+}
@@ -0,0 +1,243 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/relabel"
+	"github.com/prometheus/prometheus/util/strutil"
+)
+
+// validViewName matches the names of views, which are a part of the path of the endpoint.
+var validViewName = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// ViewParams is the parameters of a named view of service discovery, served at `/discover/{name}`.
+// A view serves a subset of the targets with its own labels, so that each job of Prometheus has its own view.
+type ViewParams struct {
+	Name string `json:"name" yaml:"name"`
+	// Selector selects the containers by their Docker labels. The keys are the names of Docker labels,
+	// and the values are the regexps which must match the whole values. Every container is selected if empty.
+	// A target deduplicated from several containers is in the view only if all of them are selected, as for tenants.
+	Selector map[string]string `json:"selector,omitempty" yaml:"selector"`
+	// IncludeDockerLabels and RegexpDockerLabels filter the Docker labels of the view,
+	// in the same way as the ones of DiscovererParams.
+	IncludeDockerLabels bool   `json:"include_docker_labels" yaml:"include_docker_labels"`
+	RegexpDockerLabels  string `json:"regexp_docker_labels,omitempty" yaml:"regexp_docker_labels"`
+	// AdditionalLabels are added to every target of the view.
+	AdditionalLabels map[string]string `json:"additional_labels,omitempty" yaml:"additional_labels"`
+	// RelabelConfigs relabels the labels of each target of the view after the labels above are applied.
+	// The targets are dropped from the view by the actions `drop` and `keep`.
+	RelabelConfigs []*relabel.Config `json:"relabel_configs,omitempty" yaml:"relabel_configs"`
+}
+
+// view is the compiled ViewParams.
+type view struct {
+	name                string
+	selector            map[model.LabelName]*regexp.Regexp
+	includeDockerLabels bool
+	regexpDockerLabels  *regexp.Regexp
+	regexpMatchCache    sync.Map
+	additionalLabels    model.LabelSet
+	relabelConfigs      []*relabel.Config
+	// sdSnapshot is the snapshot of the response of service discovery of the view.
+	sdSnapshot atomic.Pointer[sdSnapshot]
+}
+
+func newView(params *ViewParams) (*view, error) {
+	if !validViewName.MatchString(params.Name) {
+		return nil, fmt.Errorf("invalid name of view `%s`", params.Name)
+	}
+	v := &view{
+		name:                params.Name,
+		includeDockerLabels: params.IncludeDockerLabels,
+		relabelConfigs:      params.RelabelConfigs,
+	}
+	v.sdSnapshot.Store(&sdSnapshot{modified: time.Now()})
+
+	var err error
+	v.selector, err = compileSelector(params.Selector)
+	if err != nil {
+		return nil, err
+	}
+	if params.RegexpDockerLabels != "" {
+		v.regexpDockerLabels, err = regexp.Compile(params.RegexpDockerLabels)
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile regexpDockerLabels: %w", err)
+		}
+	}
+	if len(params.AdditionalLabels) > 0 {
+		v.additionalLabels = make(model.LabelSet, len(params.AdditionalLabels))
+		for name, value := range params.AdditionalLabels {
+			if !model.LabelName(name).IsValid() {
+				return nil, fmt.Errorf("invalid name of additional label `%s`", name)
+			}
+			v.additionalLabels[model.LabelName(name)] = model.LabelValue(value)
+		}
+	}
+	for i, c := range params.RelabelConfigs {
+		if c == nil {
+			return nil, fmt.Errorf("relabel config #%d is empty", i)
+		}
+		err = c.Validate()
+		if err != nil {
+			return nil, fmt.Errorf("invalid relabel config #%d: %w", i, err)
+		}
+	}
+	return v, nil
+}
+
+// newViews compiles the views and returns them keyed by their names.
+func newViews(params []*ViewParams) (map[string]*view, error) {
+	views := make(map[string]*view, len(params))
+	for _, p := range params {
+		if p == nil {
+			return nil, errors.New("view is empty")
+		}
+		if _, ok := views[p.Name]; ok {
+			return nil, fmt.Errorf("duplicated name of view `%s`", p.Name)
+		}
+		v, err := newView(p)
+		if err != nil {
+			return nil, err
+		}
+		views[p.Name] = v
+	}
+	return views, nil
+}
+
+// compileSelector compiles the selector of containers, keyed by the names of Docker labels.
+// The regexps are anchored to match the whole values.
+func compileSelector(selector map[string]string) (map[model.LabelName]*regexp.Regexp, error) {
+	if len(selector) == 0 {
+		return nil, nil
+	}
+	ret := make(map[model.LabelName]*regexp.Regexp, len(selector))
+	for name, value := range selector {
+		re, err := regexp.Compile("^(?:" + value + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("failed to compile selector of `%s`: %w", name, err)
+		}
+		ret[model.LabelName(labelNameContainerLabelPrefix+strutil.SanitizeLabelName(name))] = re
+	}
+	return ret, nil
+}
+
+// matchSelector reports whether the container discovered with ls matches all the regexps of selector.
+func matchSelector(selector map[model.LabelName]*regexp.Regexp, ls model.LabelSet) bool {
+	for name, re := range selector {
+		value, ok := ls[name]
+		if !ok || !re.MatchString(string(value)) {
+			return false
+		}
+	}
+	return true
+}
+
+// selects reports whether the container discovered with ls is in the view.
+func (v *view) selects(ls model.LabelSet) bool {
+	return matchSelector(v.selector, ls)
+}
+
+// selectsAll reports whether all the containers discovered with labels are in the view,
+// so that the view of a deduplicated target does not depend on which container is discovered first.
+func (v *view) selectsAll(labels []model.LabelSet) bool {
+	return !slices.ContainsFunc(labels, func(ls model.LabelSet) bool {
+		return !v.selects(ls)
+	})
+}
+
+// buildSDSnapshot builds the snapshot of service discovery of the view for the targets in the order of hashes.
+// It returns prev as is if the content does not change.
+func (v *view) buildSDSnapshot(h *Handler, hashes []string, targets map[string]*proxyTarget, prev *sdSnapshot) (*sdSnapshot, error) {
+	var entries []*sdEntry
+	for _, hash := range hashes {
+		pt := targets[hash]
+		if !v.selectsAll(pt.labels) {
+			continue
+		}
+		ls := pt.labels[0].Clone()
+		for _, key := range filteredLabels {
+			delete(ls, key)
+		}
+		var kept model.LabelSet
+		if v.includeDockerLabels {
+			kept = filterLabelsByRegexp(ls, v.regexpDockerLabels, &v.regexpMatchCache)
+		}
+		config := buildStaticConfig(kept, v.additionalLabels, pt.url, hash, "", "")
+		delete(config.Labels, labelNameSchemeLabel)
+
+		if len(v.relabelConfigs) > 0 {
+			lbls, keep := relabel.Process(labels.FromMap(labelSetToMap(config.Labels)), v.relabelConfigs...)
+			if !keep {
+				continue
+			}
+			config.Labels = make(model.LabelSet, lbls.Len())
+			lbls.Range(func(l labels.Label) {
+				config.Labels[model.LabelName(l.Name)] = model.LabelValue(l.Value)
+			})
+		}
+
+		entries = append(entries, &sdEntry{
+			hash:     hash,
+			labels:   config.Labels,
+			project:  string(pt.labels[0][labelNameComposeProject]),
+			shardKey: h.shardKeyOf(hash, pt),
+		})
+	}
+	return newSDSnapshot(entries, prev, time.Now())
+}
+
+// labelSetToMap converts ls to the map for labels.FromMap.
+func labelSetToMap(ls model.LabelSet) map[string]string {
+	ret := make(map[string]string, len(ls))
+	for name, value := range ls {
+		ret[string(name)] = string(value)
+	}
+	return ret
+}
+
+// buildViewSDSnapshots builds the snapshots of the views for the targets in the order of hashes, keyed by the names of views.
+// They are not stored, so that they are published together with the targets.
+// It is called only from the goroutine updating the targets.
+func (h *Handler) buildViewSDSnapshots(hashes []string, targets map[string]*proxyTarget) (map[string]*sdSnapshot, error) {
+	ret := make(map[string]*sdSnapshot, len(h.views))
+	for name, v := range h.views {
+		snapshot, err := v.buildSDSnapshot(h, hashes, targets, v.sdSnapshot.Load())
+		if err != nil {
+			return nil, fmt.Errorf("failed to build the snapshot of view `%s`: %w", v.name, err)
+		}
+		ret[name] = snapshot
+	}
+	return ret, nil
+}
+
+// ViewStaticConfigs returns the current targets of the named view in the format of HTTP service discovery.
+// scheme and address are the ones to reach prommux itself, and are written into each entry.
+func (h *Handler) ViewStaticConfigs(name, scheme, address string) ([]*StaticConfig, error) {
+	v, ok := h.views[name]
+	if !ok {
+		return nil, fmt.Errorf("view `%s` not found", name)
+	}
+	return v.sdSnapshot.Load().staticConfigs(scheme, address), nil
+}
+
+// endpointViewServiceDiscovery serves the endpoint of service discovery for the view named in the path.
+// It supports conditional requests and `shard` and `shards` query parameters as endpointServiceDiscovery does.
+func (h *Handler) endpointViewServiceDiscovery(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["view"]
+	v, ok := h.views[name]
+	if !ok {
+		http.Error(w, fmt.Sprintf("view `%s` not found", name), http.StatusNotFound)
+		return
+	}
+	h.serveSDSnapshot(w, r, tenantFromContext(r.Context()).sdSnapshot(name, v.sdSnapshot.Load()))
+}
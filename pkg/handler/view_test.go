@@ -0,0 +1,200 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/discovery/targetgroup"
+	"github.com/prometheus/prometheus/model/relabel"
+	"gopkg.in/yaml.v3"
+)
+
+// viewTarget returns the labels of a target of the compose service.
+func viewTarget(address, service, tier string) model.LabelSet {
+	return model.LabelSet{
+		labelNameAddressLabel:                  model.LabelValue(address),
+		labelNameComposeService:                model.LabelValue(service),
+		labelNameContainerLabelPrefix + "tier": model.LabelValue(tier),
+	}
+}
+
+func TestViews(t *testing.T) {
+	var views []*ViewParams
+	err := yaml.Unmarshal([]byte(`
+- name: apps
+  selector:
+    tier: app|web
+  include_docker_labels: true
+  regexp_docker_labels: _service$
+  additional_labels:
+    job_group: apps
+  relabel_configs:
+    - source_labels: [__meta_docker_container_label_com_docker_compose_service]
+      target_label: service
+    - source_labels: [service]
+      regex: canary
+      action: drop
+- name: databases
+  selector:
+    tier: db
+`), &views)
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		Views:            views,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{
+			viewTarget("a:9100", "frontend", "web"),
+			viewTarget("b:9100", "canary", "app"),
+			viewTarget("c:9187", "postgres", "db"),
+			viewTarget("d:9100", "node", "infra"),
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	apps, err := h.ViewStaticConfigs("apps", "http", "prommux:11298")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := endpointHash("http://a:9100/metrics")
+	want := []*StaticConfig{{
+		Targets: []string{"prommux:11298"},
+		Labels: model.LabelSet{
+			labelNameSchemeLabel:             "http",
+			labelNameMetricsPathLabel:        model.LabelValue("/proxy/" + hash),
+			labelNameComposeService:          "frontend",
+			"service":                        "frontend",
+			"job_group":                      "apps",
+			labelNameLabelPrommuxDetectedURL: "http://a:9100/metrics",
+		},
+	}}
+	if diff := cmp.Diff(want, apps); diff != "" {
+		t.Errorf("unexpected targets of apps (-want +got):\n%s", diff)
+	}
+
+	databases, err := h.ViewStaticConfigs("databases", "http", "prommux:11298")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(databases) != 1 || databases[0].Labels[labelNameLabelPrommuxDetectedURL] != "http://c:9187/metrics" {
+		t.Errorf("unexpected targets of databases: %+v", databases)
+	}
+	if all, _ := h.StaticConfigs("http", "prommux:11298"); len(all) != 4 {
+		t.Errorf("the views must not affect the default discovery. got: %d targets", len(all))
+	}
+
+	router := h.NewRouter()
+	for path, wantCode := range map[string]int{
+		"/discover/databases":                  http.StatusOK,
+		"/discover/databases?shard=0&shards=1": http.StatusOK,
+		"/discover/infra":                      http.StatusNotFound,
+	} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, path, nil))
+		if w.Code != wantCode {
+			t.Errorf("unexpected status code of %s. got: %d, want: %d", path, w.Code, wantCode)
+		}
+	}
+}
+
+func TestViewsDeduplicatedTarget(t *testing.T) {
+	for name, targets := range map[string][]model.LabelSet{
+		"selected first":   {viewTarget("c:9187", "postgres", "db"), viewTarget("c:9187", "proxy", "app")},
+		"unselected first": {viewTarget("c:9187", "proxy", "app"), viewTarget("c:9187", "postgres", "db")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			h, err := createTestHandler(t, nil, &HandlerParams{
+				DiscovererParams: &DiscovererParams{},
+				Views:            []*ViewParams{{Name: "databases", Selector: map[string]string{"tier": "db"}}},
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the replicas of the database are deduplicated into a target in the view
+			err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+				Targets: append(targets, viewTarget("e:9187", "postgres", "db"), viewTarget("e:9187", "postgres", "db")),
+			}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			databases, err := h.ViewStaticConfigs("databases", "http", "prommux:11298")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(databases) != 1 || databases[0].Labels[labelNameLabelPrommuxDetectedURL] != "http://e:9187/metrics" {
+				t.Errorf("the target must be in the view only if all of its containers are selected: %+v", databases)
+			}
+		})
+	}
+}
+
+func TestNewViewsInvalid(t *testing.T) {
+	for name, params := range map[string][]*ViewParams{
+		"invalid name":     {{Name: "apps/web"}},
+		"duplicated name":  {{Name: "apps"}, {Name: "apps"}},
+		"invalid selector": {{Name: "apps", Selector: map[string]string{"tier": "("}}},
+		"invalid label":    {{Name: "apps", AdditionalLabels: map[string]string{"": "apps"}}},
+		"invalid relabel":  {{Name: "apps", RelabelConfigs: []*relabel.Config{{Action: relabel.Replace}}}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			_, err := newViews(params)
+			if err == nil {
+				t.Error("error must be returned")
+			}
+		})
+	}
+}
+
+func TestViewsPublishedWithTargets(t *testing.T) {
+	h, err := createTestHandler(t, nil, &HandlerParams{
+		DiscovererParams: &DiscovererParams{},
+		Views:            []*ViewParams{{Name: "all"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, err := url.Parse("http://a:9100/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := endpointHash(u.String())
+	targets := map[string]*proxyTarget{hash: {url: u, hash: hash, labels: []model.LabelSet{viewTarget("a:9100", "frontend", "web")}}}
+	prev := h.views["all"].sdSnapshot.Load()
+
+	// the snapshots are built before the targets are replaced, and must not be served until then
+	snapshots, err := h.buildViewSDSnapshots([]string{hash}, targets)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshots["all"].entries) != 1 {
+		t.Errorf("unexpected entries of the view: %d", len(snapshots["all"].entries))
+	}
+	if h.views["all"].sdSnapshot.Load() != prev {
+		t.Error("the snapshot of the view must not be published before the targets")
+	}
+
+	err = h.updateTargets(context.Background(), []*targetgroup.Group{{
+		Targets: []model.LabelSet{viewTarget("a:9100", "frontend", "web")},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range h.views["all"].sdSnapshot.Load().entries {
+		if _, ok := h.registry.lookup(e.hash); !ok {
+			t.Errorf("the target `%s` in the view must be proxied", e.hash)
+		}
+	}
+}